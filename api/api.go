@@ -194,3 +194,16 @@ func CreateInstance(receiverType reflect.Type) reflect.Value {
 	}
 	return reflect.New(receiverType).Elem()
 }
+
+// ResultError returns an error if the last value returned by
+// the service method is a non nil error.
+func ResultError(results []reflect.Value) error {
+	if len(results) == 0 {
+		return nil
+	}
+	last := results[len(results)-1]
+	if !last.Type().Implements(reflect.TypeOf((*error)(nil)).Elem()) || last.IsNil() {
+		return nil
+	}
+	return last.Interface().(error)
+}
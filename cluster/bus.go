@@ -0,0 +1,63 @@
+// Package cluster provides a message bus for delivering websocket
+// events and direct messages between application instances.
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+)
+
+const DefChannel = "gobizapp_cluster"
+
+type MessageKind string
+
+const (
+	KindEvent  MessageKind = "event"  // event for all subscribed clients
+	KindDirect MessageKind = "direct" // message to all connections of a client ID
+	KindUser   MessageKind = "user"   // message to all connections of a user
+	KindRole   MessageKind = "role"   // message to all connections of a role
+
+	KindBroadcast     MessageKind = "broadcast"      // admin broadcast to all connections or a role
+	KindDisconnect    MessageKind = "disconnect"     // admin disconnect of a session or one connection
+	KindSessions      MessageKind = "sessions"       // request for connected sessions of every node
+	KindSessionsReply MessageKind = "sessions_reply" // connected sessions of a node, sent to ToNodeID
+	KindPresence      MessageKind = "presence"       // connection counts of users on the sending node
+	KindPresenceSync  MessageKind = "presence_sync"  // request for connection counts of every node
+)
+
+// Message is sent over the bus. NodeID is an ID of the sending node,
+// nodes skip their own messages as they have already been delivered locally.
+type Message struct {
+	NodeID      string          `json:"n"`
+	Kind        MessageKind     `json:"k"`
+	PublisherID string          `json:"pub,omitempty"`
+	EventID     string          `json:"ev,omitempty"`
+	ClientID    string          `json:"cl,omitempty"`
+	UserID      string          `json:"u,omitempty"`
+	Role        string          `json:"r,omitempty"`
+	ConnID      string          `json:"cn,omitempty"`
+	RequestID   string          `json:"rq,omitempty"`
+	ToNodeID    string          `json:"to,omitempty"`
+	Payload     json.RawMessage `json:"p"`
+}
+
+// Handler is called for every message received from the bus.
+type Handler = func(msg *Message)
+
+// Bus is implemented by cluster transports.
+type Bus interface {
+	Publish(ctx context.Context, msg *Message) error
+	Subscribe(handler Handler) error // starts receiving messages in background
+	Close() error
+}
+
+// NewNodeID generates random node ID.
+func NewNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
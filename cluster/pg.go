@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	pgMaxPayloadLen   = 7999 // NOTIFY payload limit
+	pgReconnectWait   = 100
+	pgMaxReconnetWait = 60000
+	pgStoredPrefix    = "#" // notification payload with an ID of a stored message
+	pgStoredKeep      = "5 minutes"
+
+	DefPgTable = "public.cluster_messages"
+)
+
+// PgBus is a bus implementation based on Postgres NOTIFY.
+// Messages are published through the pool, listening is done on a
+// dedicated connection opened with ConnStr, as pool connections
+// can have their own notification handler.
+// Messages longer than the NOTIFY limit are stored in Table and only their
+// ID is notified, stored messages are deleted after 5 minutes,
+// see database/schema/cluster.sql.
+type PgBus struct {
+	ConnStr string
+	Pool    *pgxpool.Pool
+	Channel string
+	Table   string // DefPgTable if empty
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPgBus(connStr string, pool *pgxpool.Pool, channel string) *PgBus {
+	if channel == "" {
+		channel = DefChannel
+	}
+	return &PgBus{ConnStr: connStr, Pool: pool, Channel: channel, Table: DefPgTable}
+}
+
+func (b *PgBus) table() string {
+	t := b.Table
+	if t == "" {
+		t = DefPgTable
+	}
+	return pgx.Identifier(strings.Split(t, ".")).Sanitize()
+}
+
+func (b *PgBus) Publish(ctx context.Context, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %v", err)
+	}
+	payload := string(data)
+	if len(data) > pgMaxPayloadLen {
+		var id int64
+		if err := b.Pool.QueryRow(ctx,
+			`INSERT INTO `+b.table()+` (channel, payload) VALUES ($1, $2) RETURNING id`,
+			b.Channel, payload,
+		).Scan(&id); err != nil {
+			return fmt.Errorf("PgBus Publish() INSERT: %v", err)
+		}
+		payload = pgStoredPrefix + strconv.FormatInt(id, 10)
+
+		if _, err := b.Pool.Exec(ctx,
+			`DELETE FROM `+b.table()+` WHERE created_at < now() - interval '`+pgStoredKeep+`'`,
+		); err != nil {
+			logger.Logger.Errorf("PgBus Publish() DELETE: %v", err)
+		}
+	}
+	if _, err := b.Pool.Exec(ctx, `SELECT pg_notify($1, $2)`, b.Channel, payload); err != nil {
+		return fmt.Errorf("PgBus Publish() pg_notify: %v", err)
+	}
+	return nil
+}
+
+func (b *PgBus) Subscribe(handler Handler) error {
+	var ctx context.Context
+	ctx, b.cancel = context.WithCancel(context.Background())
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+
+		wait := pgReconnectWait
+		for {
+			started := time.Now()
+			if err := b.listen(ctx, handler); err != nil && ctx.Err() == nil {
+				logger.Logger.Errorf("PgBus listen(): %v", err)
+			}
+			// a connection that has been working for a while is an ordinary
+			// disconnect, backoff starts over
+			if time.Since(started) > time.Duration(wait)*time.Millisecond {
+				wait = pgReconnectWait
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(wait) * time.Millisecond):
+			}
+			wait = min(wait*2, pgMaxReconnetWait)
+		}
+	}()
+
+	return nil
+}
+
+func (b *PgBus) listen(ctx context.Context, handler Handler) error {
+	conn, err := pgx.Connect(ctx, b.ConnStr)
+	if err != nil {
+		return fmt.Errorf("pgx.Connect(): %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, `LISTEN `+pgx.Identifier{b.Channel}.Sanitize()); err != nil {
+		return fmt.Errorf("LISTEN: %v", err)
+	}
+	logger.Logger.Debugf("PgBus listening on channel %s", b.Channel)
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("conn.WaitForNotification(): %v", err)
+		}
+		payload := n.Payload
+		if id, ok := strings.CutPrefix(payload, pgStoredPrefix); ok {
+			if err := conn.QueryRow(ctx,
+				`SELECT payload FROM `+b.table()+` WHERE id = $1`, id,
+			).Scan(&payload); err != nil {
+				logger.Logger.Errorf("PgBus stored message %s SELECT: %v", id, err)
+				continue
+			}
+		}
+		msg := Message{}
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			logger.Logger.Errorf("PgBus json.Unmarshal(): %v", err)
+			continue
+		}
+		handler(&msg)
+	}
+}
+
+func (b *PgBus) Close() error {
+	if b.cancel == nil {
+		return nil
+	}
+	b.cancel()
+	<-b.done
+	return nil
+}
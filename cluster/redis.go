@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dronm/gobizapp/logger"
+)
+
+// RedisBus is a bus implementation based on Redis pub/sub.
+type RedisBus struct {
+	Client  redis.UniversalClient
+	Channel string
+
+	pubSub *redis.PubSub
+	done   chan struct{}
+}
+
+func NewRedisBus(client redis.UniversalClient, channel string) *RedisBus {
+	if channel == "" {
+		channel = DefChannel
+	}
+	return &RedisBus{Client: client, Channel: channel}
+}
+
+func (b *RedisBus) Publish(ctx context.Context, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %v", err)
+	}
+	if err := b.Client.Publish(ctx, b.Channel, data).Err(); err != nil {
+		return fmt.Errorf("RedisBus Publish(): %v", err)
+	}
+	return nil
+}
+
+// Subscribe subscribes to the channel, reconnection is handled by the redis client.
+func (b *RedisBus) Subscribe(handler Handler) error {
+	ctx := context.Background()
+	b.pubSub = b.Client.Subscribe(ctx, b.Channel)
+	if _, err := b.pubSub.Receive(ctx); err != nil {
+		b.pubSub.Close()
+		return fmt.Errorf("RedisBus Subscribe(): %v", err)
+	}
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+
+		for m := range b.pubSub.Channel() {
+			msg := Message{}
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				logger.Logger.Errorf("RedisBus json.Unmarshal(): %v", err)
+				continue
+			}
+			handler(&msg)
+		}
+	}()
+
+	return nil
+}
+
+func (b *RedisBus) Close() error {
+	if b.pubSub == nil {
+		return nil
+	}
+	err := b.pubSub.Close()
+	<-b.done
+	return err
+}
@@ -1,11 +1,15 @@
 package controllers
 
 import (
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/dronm/gobizapp/database"
+	"github.com/dronm/gobizapp/errs"
+	"github.com/dronm/gobizapp/notif"
 
 	"github.com/dronm/gobizapp/models"
 	"github.com/dronm/gobizapp/services"
@@ -53,3 +57,51 @@ func NotifOutMessageDetail(c *gin.Context) {
 	c.JSON(http.StatusOK, model)
 }
 
+const notifCallbackMaxBody = 1 << 20
+
+// NotifOutMessageCallback receives delivery status reports, no session is required,
+// the body must be signed with the application callback key in notif.WebhookSignatureHeader
+// together with the timestamp in notif.WebhookTimestampHeader.
+func NotifOutMessageCallback(c *gin.Context) {
+	funcName := "NotifOutMessageCallback"
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, notifCallbackMaxBody))
+	if err != nil {
+		ServeError(c, http.StatusBadRequest, funcName+" io.ReadAll()", err)
+		return
+	}
+
+	serv := services.NewNotifOutMessageService(database.DB, nil)
+	applied, err := serv.ApplyCallback(c.Request.Context(), body,
+		c.GetHeader(notif.WebhookSignatureHeader), c.GetHeader(notif.WebhookTimestampHeader),
+	)
+	if errors.Is(err, services.ErrNotifCallbackSignature) {
+		ServeError(c, http.StatusForbidden, funcName, errs.NewPublicError(errs.NotAllowed))
+		return
+	} else if err != nil {
+		ServeError(c, http.StatusBadRequest, funcName+" ApplyCallback()", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"applied": applied})
+}
+
+// NotifUnsubscribe handles unsubscribe links, no session is required,
+// the recipient and the message type are taken from the signed token.
+func NotifUnsubscribe(c *gin.Context) {
+	funcName := "NotifUnsubscribe"
+
+	token := c.Query("token")
+	if token == "" {
+		ServeError(c, http.StatusBadRequest, funcName, errs.NewPublicError(errs.ValidationFailed))
+		return
+	}
+	serv := services.NewNotifRecipientPrefService(database.DB, nil)
+	if err := serv.Unsubscribe(c.Request.Context(), token); errors.Is(err, notif.ErrUnsubscribeToken) {
+		ServeError(c, http.StatusForbidden, funcName, errs.NewPublicError(errs.NotAllowed))
+		return
+	} else if err != nil {
+		ServeError(c, http.StatusInternalServerError, funcName+" Unsubscribe()", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unsubscribed": true})
+}
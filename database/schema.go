@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Schema holds DDL of the tables used by the framework packages,
+// every script is idempotent and can be applied on each start.
+//
+//go:embed schema/*.sql
+var Schema embed.FS
+
+// DBExecer is implemented by pgx.Conn, pgx.Tx and pgxpool.Pool.
+type DBExecer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// ApplySchema runs schema scripts by name, e.g. "cluster.sql",
+// all scripts in name order if no names are given.
+func ApplySchema(ctx context.Context, db DBExecer, names ...string) error {
+	if len(names) == 0 {
+		files, err := fs.Glob(Schema, "schema/*.sql")
+		if err != nil {
+			return fmt.Errorf("ApplySchema(): %v", err)
+		}
+		for _, f := range files {
+			names = append(names, f[len("schema/"):])
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		sql, err := Schema.ReadFile("schema/" + name)
+		if err != nil {
+			return fmt.Errorf("ApplySchema(): %v", err)
+		}
+		if _, err := db.Exec(ctx, string(sql)); err != nil {
+			return fmt.Errorf("ApplySchema() %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+-- Cluster bus messages longer than the NOTIFY payload limit, see cluster.PgBus.
+CREATE TABLE IF NOT EXISTS public.cluster_messages (
+	id bigserial PRIMARY KEY,
+	channel text NOT NULL,
+	payload text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS cluster_messages_created_at_idx ON public.cluster_messages (created_at);
@@ -0,0 +1,10 @@
+-- Local events which consumers failed after all retries, see eventServer.LocalHandlerOptions.
+CREATE TABLE IF NOT EXISTS public.event_dead_letters (
+	id serial PRIMARY KEY,
+	event_id text NOT NULL,
+	payload text,
+	error_text text,
+	attempts int NOT NULL DEFAULT 0,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS event_dead_letters_created_at_idx ON public.event_dead_letters (created_at);
@@ -0,0 +1,13 @@
+-- Recipient delivery preferences, see services.InitNotifPrefs.
+CREATE SCHEMA IF NOT EXISTS notifications;
+
+CREATE TABLE IF NOT EXISTS notifications.recipient_prefs (
+	app_id int NOT NULL,
+	recipient_id text NOT NULL,
+	opt_ins jsonb, -- providers by message type, "*" for all types
+	provider_order text[],
+	quiet_hours jsonb, -- [{"from": "HH:MM", "to": "HH:MM"}]
+	time_zone text,
+	unsubscribed text[], -- message types, "*" for all types
+	PRIMARY KEY (app_id, recipient_id)
+);
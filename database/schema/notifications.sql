@@ -0,0 +1,62 @@
+-- Notification outbox, see notif.Outbox.
+-- out_messages may already exist in the gateway database,
+-- columns used by the outbox are added to it then.
+CREATE SCHEMA IF NOT EXISTS notifications;
+
+CREATE TABLE IF NOT EXISTS notifications.out_messages (
+	id serial PRIMARY KEY,
+	app_id int NOT NULL,
+	providers text[] NOT NULL DEFAULT '{}',
+	message jsonb,
+	message_type text,
+	status int NOT NULL DEFAULT 0,
+	callback bool NOT NULL DEFAULT FALSE,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	closed bool NOT NULL DEFAULT FALSE
+);
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS attempts int NOT NULL DEFAULT 0;
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz;
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS last_error text;
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS provider text;
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS recipient_id text;
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS dedup_key text;
+ALTER TABLE notifications.out_messages ADD COLUMN IF NOT EXISTS digest_key text;
+CREATE INDEX IF NOT EXISTS out_messages_due_idx ON notifications.out_messages (app_id, next_attempt_at) WHERE NOT closed;
+CREATE INDEX IF NOT EXISTS out_messages_recipient_id_idx ON notifications.out_messages (app_id, recipient_id) WHERE recipient_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS out_messages_digest_key_idx ON notifications.out_messages (app_id, digest_key) WHERE digest_key IS NOT NULL;
+
+-- Provider calls made by the outbox, recipient is used by rate limits.
+CREATE TABLE IF NOT EXISTS notifications.out_message_attempts (
+	id serial PRIMARY KEY,
+	out_message_id int NOT NULL REFERENCES notifications.out_messages (id) ON DELETE CASCADE,
+	attempt int NOT NULL,
+	provider text NOT NULL,
+	recipient text,
+	ok bool NOT NULL,
+	error_text text,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+ALTER TABLE notifications.out_message_attempts ADD COLUMN IF NOT EXISTS recipient text;
+CREATE INDEX IF NOT EXISTS out_message_attempts_out_message_id_idx ON notifications.out_message_attempts (out_message_id);
+CREATE INDEX IF NOT EXISTS out_message_attempts_recipient_idx ON notifications.out_message_attempts (provider, recipient, created_at) WHERE ok;
+
+-- Status history from delivery callbacks.
+CREATE TABLE IF NOT EXISTS notifications.out_message_statuses (
+	id serial PRIMARY KEY,
+	out_message_id int NOT NULL REFERENCES notifications.out_messages (id) ON DELETE CASCADE,
+	status int NOT NULL,
+	provider text,
+	error_text text,
+	reported_at timestamptz,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS out_message_statuses_out_message_id_idx ON notifications.out_message_statuses (out_message_id);
+
+-- Dedup keys claimed by outbox messages till expires_at, see notif.Policy.
+CREATE TABLE IF NOT EXISTS notifications.out_message_dedup (
+	app_id int NOT NULL,
+	dedup_key text NOT NULL,
+	out_message_id int,
+	expires_at timestamptz NOT NULL,
+	PRIMARY KEY (app_id, dedup_key)
+);
@@ -0,0 +1,28 @@
+-- Delayed service calls and job history, see scheduler.Scheduler.
+CREATE TABLE IF NOT EXISTS public.scheduled_events (
+	id bigserial PRIMARY KEY,
+	run_at timestamptz NOT NULL,
+	service text NOT NULL,
+	method text NOT NULL,
+	params text[] NOT NULL DEFAULT '{}',
+	status text NOT NULL DEFAULT 'pending',
+	session_id text, -- session of the user who queued the call, system session if null
+	error_text text,
+	started_at timestamptz,
+	finished_at timestamptz,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS scheduled_events_status_run_at_idx ON public.scheduled_events (status, run_at);
+
+CREATE TABLE IF NOT EXISTS public.scheduler_history (
+	id bigserial PRIMARY KEY,
+	job_id text NOT NULL,
+	service text NOT NULL,
+	method text NOT NULL,
+	params text[] NOT NULL DEFAULT '{}',
+	started_at timestamptz NOT NULL,
+	finished_at timestamptz NOT NULL,
+	status text NOT NULL,
+	error_text text
+);
+CREATE INDEX IF NOT EXISTS scheduler_history_started_at_idx ON public.scheduler_history (started_at);
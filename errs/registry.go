@@ -20,6 +20,10 @@ const (
 	UserEmailNotFound   ErrorCode = "USER_EMAIL_NOT_FOUND"
 	DBKeyExists         ErrorCode = "DB_KEY_EXISTS"
 	DBRefExists         ErrorCode = "DB_REF_EXISTS"
+	ServerRestarting    ErrorCode = "SERVER_RESTARTING"
+	InvalidTel          ErrorCode = "INVALID_TEL"
+	TooManyCalls        ErrorCode = "TOO_MANY_CALLS"
+	DuplicateCall       ErrorCode = "DUPLICATE_CALL"
 )
 
 var errorRegistry = map[ErrorCode]string{
@@ -39,6 +43,10 @@ var errorRegistry = map[ErrorCode]string{
 	UserEmailNotFound:   "Электронная почта не неайдена",
 	DBKeyExists:         "Нарушение уникального ключа",
 	DBRefExists:         "Существуют ссылки",
+	ServerRestarting:    "Сервер перезапускается, повторите запрос позже",
+	InvalidTel:          "Неверный номер телефона: %s",
+	TooManyCalls:        "Слишком много одновременных запросов, повторите запрос позже",
+	DuplicateCall:       "Запрос с таким идентификатором уже выполняется",
 }
 
 func ErrorDescr(code ErrorCode) string {
@@ -0,0 +1,236 @@
+package eventServer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dronm/gobizapp/api"
+	"github.com/dronm/gobizapp/database"
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	defLocalMaxAttempts = 3
+	defLocalBackoff     = time.Duration(1) * time.Second
+	defLocalMaxBackoff  = time.Duration(1) * time.Minute
+	defLocalTimeout     = time.Duration(1) * time.Minute
+	defLocalConcurrency = 1
+	defLocalQueueSize   = 1000
+
+	deadLetterRelation = "event_dead_letters"
+)
+
+// LocalHandlerOptions configures local event consumer.
+// Zero values are replaced with defaults.
+type LocalHandlerOptions struct {
+	MaxAttempts int           // total number of calls including the first one
+	Backoff     time.Duration // pause before the first retry, doubled on every next retry
+	MaxBackoff  time.Duration // max pause between retries
+	Timeout     time.Duration // max duration of one call
+	Concurrency int           // max number of simultaneous calls of the handler
+	QueueSize   int           // max number of events waiting for a call
+}
+
+func (o *LocalHandlerOptions) setDefaults() {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defLocalMaxAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = defLocalBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defLocalMaxBackoff
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defLocalTimeout
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = defLocalConcurrency
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = defLocalQueueSize
+	}
+}
+
+type localEvent struct {
+	eventID string
+	service string
+	method  string
+	params  []string
+	payload string
+}
+
+// localHandler has a bounded event queue served by Concurrency workers.
+type localHandler struct {
+	opts  LocalHandlerOptions
+	queue chan *localEvent
+	start sync.Once
+}
+
+func newLocalHandler(opts LocalHandlerOptions) *localHandler {
+	opts.setDefaults()
+	return &localHandler{opts: opts, queue: make(chan *localEvent, opts.QueueSize)}
+}
+
+// SetLocalHandler registers a local consumer for the event with the given options.
+// Event ID is in Service.Method format. It should be called before Serve().
+// Events from LocalEvents map without explicit options are handled with defaults.
+func (s *EventServer) SetLocalHandler(eventID string, opts LocalHandlerOptions) {
+	s.localHandlersMx.Lock()
+	defer s.localHandlersMx.Unlock()
+
+	if s.LocalEvents == nil {
+		s.LocalEvents = make(map[string]struct{})
+	}
+	if s.localHandlers == nil {
+		s.localHandlers = make(map[string]*localHandler)
+	}
+	s.LocalEvents[eventID] = struct{}{}
+	s.localHandlers[eventID] = newLocalHandler(opts)
+}
+
+// localHandler returns handler for the event, creates default one if not registered.
+func (s *EventServer) localHandler(eventID string) *localHandler {
+	s.localHandlersMx.Lock()
+	defer s.localHandlersMx.Unlock()
+
+	if s.localHandlers == nil {
+		s.localHandlers = make(map[string]*localHandler)
+	}
+	h, ok := s.localHandlers[eventID]
+	if !ok {
+		h = newLocalHandler(LocalHandlerOptions{})
+		s.localHandlers[eventID] = h
+	}
+	return h
+}
+
+// DispatchLocalEvent queues the event for its local consumer.
+// Failed calls are retried, after the last attempt the event is stored
+// in the dead letter table. Events which can not be queued because
+// the queue is full or are left in the queue on shutdown are stored
+// as dead letters as well.
+func (s *EventServer) DispatchLocalEvent(eventID, payload string) error {
+	if s.ctx == nil {
+		return fmt.Errorf("EventServer is not started")
+	}
+	srvMeth := strings.Split(eventID, ".")
+	if len(srvMeth) != 2 {
+		return fmt.Errorf("invalid service.method signature for: %s", eventID)
+	}
+	params, err := api.UnmarshalParams([]byte(payload))
+	if err != nil {
+		return fmt.Errorf("api.UnmarshalParams: %v", err)
+	}
+
+	h := s.localHandler(eventID)
+	h.start.Do(func() {
+		for i := 0; i < h.opts.Concurrency; i++ {
+			s.localWG.Add(1)
+			go s.localWorker(h)
+		}
+	})
+
+	ev := &localEvent{eventID: eventID, service: srvMeth[0], method: srvMeth[1], params: params, payload: payload}
+	select {
+	case h.queue <- ev:
+	default:
+		err := fmt.Errorf("local event queue is full (%d events)", h.opts.QueueSize)
+		logger.Logger.Errorf("EventServer DispatchLocalEvent(%s): %v", eventID, err)
+		s.storeDeadLetter(eventID, payload, 0, err)
+	}
+
+	return nil
+}
+
+// localWorker runs queued events until the server stops,
+// then moves the rest of the queue to dead letters.
+func (s *EventServer) localWorker(h *localHandler) {
+	defer s.localWG.Done()
+
+	for {
+		select {
+		case ev := <-h.queue:
+			s.runLocalHandler(h, ev)
+		case <-s.ctx.Done():
+			for {
+				select {
+				case ev := <-h.queue:
+					s.storeDeadLetter(ev.eventID, ev.payload, 0, s.ctx.Err())
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *EventServer) runLocalHandler(h *localHandler, ev *localEvent) {
+	backoff := h.opts.Backoff
+	var err error
+	attempt := 1
+	for ; attempt <= h.opts.MaxAttempts; attempt++ {
+		logger.Logger.Debugf("EventServer local service call %s.%s with params %v, attempt %d", ev.service, ev.method, ev.params, attempt)
+
+		if err = s.callLocal(ev.service, ev.method, ev.params, h.opts.Timeout); err == nil {
+			return
+		}
+		logger.Logger.Errorf("EventServer local call %s.%s with params %v, attempt %d failed: %v", ev.service, ev.method, ev.params, attempt, err)
+
+		if attempt == h.opts.MaxAttempts {
+			break
+		}
+		select {
+		case <-s.ctx.Done():
+			s.storeDeadLetter(ev.eventID, ev.payload, attempt, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, h.opts.MaxBackoff)
+	}
+
+	s.storeDeadLetter(ev.eventID, ev.payload, attempt, err)
+}
+
+func (s *EventServer) callLocal(service, method string, params []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	results, err := api.CallMethod(ctx, service, method, params,
+		&api.ServiceContext{DB: database.DB, Session: s.SystemSession},
+	)
+	if err != nil {
+		return err
+	}
+	return api.ResultError(results)
+}
+
+// storeDeadLetter saves failed event to the database.
+func (s *EventServer) storeDeadLetter(eventID, payload string, attempts int, callErr error) {
+	if database.DB == nil {
+		logger.Logger.Errorf("EventServer storeDeadLetter: database is not initialized, event %s lost", eventID)
+		return
+	}
+	poolConn, connID, err := database.DB.GetPrimary()
+	if err != nil {
+		logger.Logger.Errorf("EventServer storeDeadLetter GetPrimary(): %v, event %s lost", err, eventID)
+		return
+	}
+	defer database.DB.Release(poolConn, connID)
+
+	var errText string
+	if callErr != nil {
+		errText = callErr.Error()
+	}
+	if _, err := poolConn.Exec(context.Background(),
+		`INSERT INTO `+deadLetterRelation+`
+		(event_id, payload, error_text, attempts)
+		VALUES ($1, $2, $3, $4)`,
+		eventID, payload, errText, attempts,
+	); err != nil {
+		logger.Logger.Errorf("EventServer storeDeadLetter INSERT: %v, event %s lost", err, eventID)
+	}
+}
@@ -11,11 +11,10 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
-	"github.com/dronm/gobizapp/api"
 	"github.com/dronm/session"
 
-	"github.com/dronm/gobizapp/database"
 	"github.com/dronm/gobizapp/logger"
+	gbSession "github.com/dronm/gobizapp/session"
 )
 
 const (
@@ -88,6 +87,13 @@ type SocketServer interface {
 	PublishEvent(publisherID, eventID string, payload any) error
 }
 
+// LocalSocketServer is implemented by socket servers delivering events to
+// other app instances. Database events are received by every instance,
+// so they are published to local clients only.
+type LocalSocketServer interface {
+	PublishLocalEvent(publisherID, eventID string, payload any) error
+}
+
 // EventServer is the main server structure.
 type EventServer struct {
 	DBPool       *pgxpool.Pool //
@@ -96,18 +102,23 @@ type EventServer struct {
 	Events       *UniqEvents // count of unique events for db
 	LocalEvents  map[string]struct{}
 
+	// SystemSession is passed to local event consumers.
+	SystemSession session.Session
+
+	localHandlers   map[string]*localHandler
+	localHandlersMx sync.Mutex
+	localWG         sync.WaitGroup // local event workers
+
 	ctx        context.Context
 	cancel     context.CancelFunc
 	cancelDone chan struct{}
 
-	sess session.Session
-
 	loopPause time.Duration
 	// ReconnectParams waitStrat.WaitStrategy
 }
 
 func NewEventServer(localEvents map[string]struct{}) *EventServer {
-	return &EventServer{LocalEvents: localEvents}
+	return &EventServer{LocalEvents: localEvents, SystemSession: gbSession.NewSystemSession()}
 }
 
 // OnNotification is called when there is a new event coming from pg.
@@ -127,19 +138,8 @@ func (s *EventServer) OnNotification(_ *pgconn.PgConn, n *pgconn.Notification) {
 	if s.LocalEvents != nil {
 		if _, ok := s.LocalEvents[n.Channel]; ok {
 			// local cosumer, execute service function
-			params, err := api.UnmarshalParams([]byte(n.Payload))
-			if err != nil {
-				logger.Logger.Errorf("OnNotification api.UnmarshalParams: %v", err)
-				return
-			}
-
-			logger.Logger.Debugf("EventServer local service call %s.%s with params %v", srvMeth[0], srvMeth[1], params)
-
-			_, err = api.CallMethod(s.ctx, srvMeth[0], srvMeth[1], params,
-				&api.ServiceContext{DB: database.DB, Session: s.sess},
-			)
-			if err != nil {
-				logger.Logger.Errorf("EventServer api.CallMethod() %s.%s with params %v, failed: %v", srvMeth[0], srvMeth[1], params, err)	
+			if err := s.DispatchLocalEvent(n.Channel, n.Payload); err != nil {
+				logger.Logger.Errorf("OnNotification DispatchLocalEvent(): %v", err)
 			}
 			return
 		}
@@ -160,6 +160,12 @@ func (s *EventServer) OnNotification(_ *pgconn.PgConn, n *pgconn.Notification) {
 		logger.Logger.Errorf("EventSrv: invalid JSON payload from PG: %v", err)
 		return
 	}
+	if localSrv, ok := s.SocketServer.(LocalSocketServer); ok {
+		if err := localSrv.PublishLocalEvent("", n.Channel, raw); err != nil {
+			logger.Logger.Errorf("EventSrv: PublishLocalEvent(): %v", err)
+		}
+		return
+	}
 	if err := s.SocketServer.PublishEvent("" ,n.Channel, raw); err != nil {
 		logger.Logger.Errorf("EventSrv: PublishEvent(): %v", err)
 	}
@@ -264,6 +270,17 @@ func (s *EventServer) Shutdown(ctx context.Context) {
 	case <-ctx.Done():
 	case <-s.cancelDone:
 	}
+
+	// workers store queued local events as dead letters
+	workersDone := make(chan struct{})
+	go func() {
+		s.localWG.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-ctx.Done():
+	case <-workersDone:
+	}
 	logger.Logger.Info("EventServer stopped")
 }
 
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+const (
+	eventDeadLetterRelation = "event_dead_letters"
+)
+
+// EventDeadLetter is a local event which consumer failed after all retries.
+type EventDeadLetter struct {
+	ID        int       `json:"id" primaryKey:"true" srvCalc:"true"`
+	EventID   string    `json:"event_id"`
+	Payload   string    `json:"payload"`
+	ErrorText string    `json:"error_text"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (m EventDeadLetter) Relation() string {
+	return eventDeadLetterRelation
+}
+
+func (m EventDeadLetter) CollectionAgg() any {
+	return &TotCount{0}
+}
+
+// object key model
+type EventDeadLetterKey struct {
+	ID int `json:"id" required:"true"`
+}
+
+func (m EventDeadLetterKey) Relation() string {
+	return eventDeadLetterRelation
+}
@@ -6,20 +6,28 @@ import (
 )
 
 const (
-	notifOutMessageRelation = "notifications.out_messages"
+	notifOutMessageRelation        = "notifications.out_messages"
+	notifOutMessageAttemptRelation = "notifications.out_message_attempts"
+	notifOutMessageStatusRelation  = "notifications.out_message_statuses"
 )
 
 // object model for insert/update
 type NotifOutMessage struct {
-	ID          int                        `json:"id" primaryKey:"true"`
-	AppIS       int                        `json:"app_id"`
-	Providers   []string                   `json:"providers"`
-	Message     map[string]json.RawMessage `json:"message"`
-	MessageType string                     `json:"message_type"`
-	Status      int                        `json:"status"`
-	Callback    bool                       `json:"callback"`
-	CreatedAt   time.Time                  `json:"created_at"`
-	Closed      bool                       `json:"closed"`
+	ID            int                        `json:"id" primaryKey:"true"`
+	AppIS         int                        `json:"app_id"`
+	Providers     []string                   `json:"providers"`
+	Message       map[string]json.RawMessage `json:"message"`
+	MessageType   string                     `json:"message_type"`
+	Status        int                        `json:"status"`
+	Callback      bool                       `json:"callback"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	Closed        bool                       `json:"closed"`
+	Attempts      int                        `json:"attempts"`        // delivery rounds done by the outbox
+	NextAttemptAt *time.Time                 `json:"next_attempt_at"` // next outbox delivery round
+	LastError     *string                    `json:"last_error"`
+	Provider      *string                    `json:"provider"` // provider which delivered the message
+	DedupKey      *string                    `json:"dedup_key"`
+	DigestKey     *string                    `json:"digest_key"` // messages merged into one digest
 }
 
 func (m NotifOutMessage) Relation() string {
@@ -39,3 +47,42 @@ type NotifOutMessageKey struct {
 func (m NotifOutMessageKey) Relation() string {
 	return notifOutMessageRelation
 }
+
+// NotifOutMessageAttempt is a provider call made by the outbox.
+type NotifOutMessageAttempt struct {
+	ID           int       `json:"id" primaryKey:"true" srvCalc:"true"`
+	OutMessageID int       `json:"out_message_id"`
+	Attempt      int       `json:"attempt"`
+	Provider     string    `json:"provider"`
+	Recipient    string    `json:"recipient"` // provider address, used by rate limits
+	Ok           bool      `json:"ok"`
+	ErrorText    string    `json:"error_text"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (m NotifOutMessageAttempt) Relation() string {
+	return notifOutMessageAttemptRelation
+}
+
+func (m NotifOutMessageAttempt) CollectionAgg() any {
+	return &TotCount{0}
+}
+
+// NotifOutMessageStatus is a status history record from delivery callbacks.
+type NotifOutMessageStatus struct {
+	ID           int        `json:"id" primaryKey:"true" srvCalc:"true"`
+	OutMessageID int        `json:"out_message_id"`
+	Status       int        `json:"status"`
+	Provider     string     `json:"provider"`
+	ErrorText    string     `json:"error_text"`
+	ReportedAt   *time.Time `json:"reported_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func (m NotifOutMessageStatus) Relation() string {
+	return notifOutMessageStatusRelation
+}
+
+func (m NotifOutMessageStatus) CollectionAgg() any {
+	return &TotCount{0}
+}
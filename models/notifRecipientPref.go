@@ -0,0 +1,39 @@
+package models
+
+const (
+	notifRecipientPrefRelation = "notifications.recipient_prefs"
+)
+
+type NotifQuietHours struct {
+	From string `json:"from"` // HH:MM
+	To   string `json:"to"`   // HH:MM
+}
+
+// NotifRecipientPref is a recipient delivery preferences.
+type NotifRecipientPref struct {
+	AppID         int                 `json:"app_id" primaryKey:"true"`
+	RecipientID   string              `json:"recipient_id" primaryKey:"true" required:"true"`
+	OptIns        map[string][]string `json:"opt_ins"` // providers by message type, "*" for all types
+	ProviderOrder []string            `json:"provider_order"`
+	QuietHours    []NotifQuietHours   `json:"quiet_hours"`
+	TimeZone      string              `json:"time_zone"`
+	Unsubscribed  []string            `json:"unsubscribed"` // message types, "*" for all types
+}
+
+func (m NotifRecipientPref) Relation() string {
+	return notifRecipientPrefRelation
+}
+
+func (m NotifRecipientPref) CollectionAgg() any {
+	return &TotCount{0}
+}
+
+// object key model
+type NotifRecipientPrefKey struct {
+	AppID       int    `json:"app_id" required:"true"`
+	RecipientID string `json:"recipient_id" required:"true"`
+}
+
+func (m NotifRecipientPrefKey) Relation() string {
+	return notifRecipientPrefRelation
+}
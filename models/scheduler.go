@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+const (
+	scheduledEventRelation   = "scheduled_events"
+	schedulerHistoryRelation = "scheduler_history"
+)
+
+// ScheduledEvent is a one-off delayed service call.
+type ScheduledEvent struct {
+	ID         int        `json:"id" primaryKey:"true" srvCalc:"true"`
+	RunAt      time.Time  `json:"run_at"`
+	Service    string     `json:"service"`
+	Method     string     `json:"method"`
+	Params     []string   `json:"params"`
+	Status     string     `json:"status"`
+	ErrorText  *string    `json:"error_text"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+func (m ScheduledEvent) Relation() string {
+	return scheduledEventRelation
+}
+
+func (m ScheduledEvent) CollectionAgg() any {
+	return &TotCount{0}
+}
+
+// object key model
+type ScheduledEventKey struct {
+	ID int `json:"id" required:"true"`
+}
+
+func (m ScheduledEventKey) Relation() string {
+	return scheduledEventRelation
+}
+
+// SchedulerHistory is a record of every job execution.
+type SchedulerHistory struct {
+	ID         int       `json:"id" primaryKey:"true" srvCalc:"true"`
+	JobID      string    `json:"job_id"`
+	Service    string    `json:"service"`
+	Method     string    `json:"method"`
+	Params     []string  `json:"params"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Status     string    `json:"status"`
+	ErrorText  string    `json:"error_text"`
+}
+
+func (m SchedulerHistory) Relation() string {
+	return schedulerHistoryRelation
+}
+
+func (m SchedulerHistory) CollectionAgg() any {
+	return &TotCount{0}
+}
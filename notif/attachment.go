@@ -0,0 +1,157 @@
+package notif
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// attachmentSourcesParam is an email parameter with []Attachment,
+// it is never sent as is.
+const attachmentSourcesParam = "attachment_sources"
+
+// Attachment is an email attachment opened at sending time,
+// the reader is closed right after it is sent.
+type Attachment struct {
+	Name  string // file name in the message
+	open  func(ctx context.Context) (io.ReadCloser, error)
+	close func() // releases the source if it has not been opened
+}
+
+// Open returns attachment content.
+func (a Attachment) Open(ctx context.Context) (io.ReadCloser, error) {
+	if a.open == nil {
+		return nil, fmt.Errorf("attachment %s has no source", a.Name)
+	}
+	return a.open(ctx)
+}
+
+// Close releases the source of an attachment which has not been sent,
+// e.g. a reader passed to ReaderAttachment. It is called by Notifier
+// for every message of the batch, sent or not.
+func (a Attachment) Close() {
+	if a.close != nil {
+		a.close()
+	}
+}
+
+// NewAttachment returns an attachment with a custom source,
+// e.g. a database row read on Open.
+func NewAttachment(name string, open func(ctx context.Context) (io.ReadCloser, error)) Attachment {
+	return Attachment{Name: name, open: open}
+}
+
+// FileAttachment returns an attachment of the file, alias is an optional file name.
+func FileAttachment(path, alias string) Attachment {
+	name := alias
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	return NewAttachment(name, func(ctx context.Context) (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+// BytesAttachment returns an attachment with in-memory content.
+func BytesAttachment(name string, data []byte) Attachment {
+	return NewAttachment(name, func(ctx context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+}
+
+// ReaderAttachment returns an attachment reading r. The reader can be
+// read only once, so the message can not be retried with another provider.
+// If r is an io.Closer, it is closed after sending or on Close
+// if the message has not been sent.
+func ReaderAttachment(name string, r io.Reader) Attachment {
+	var once sync.Once
+	a := NewAttachment(name, func(ctx context.Context) (io.ReadCloser, error) {
+		var rc io.ReadCloser
+		once.Do(func() {
+			if c, ok := r.(io.ReadCloser); ok {
+				rc = c
+			} else {
+				rc = io.NopCloser(r)
+			}
+		})
+		if rc == nil {
+			return nil, fmt.Errorf("attachment %s has already been read", name)
+		}
+		return rc, nil
+	})
+	a.close = func() {
+		once.Do(func() {
+			if c, ok := r.(io.Closer); ok {
+				c.Close()
+			}
+		})
+	}
+	return a
+}
+
+// emailAttachments returns attachments of the email provider parameters:
+// file paths with aliases followed by attachment sources.
+func emailAttachments(msg map[string]any) []Attachment {
+	paths := stringList(msg["attachments"])
+	aliases := stringList(msg["attachment_alias"])
+	var list []Attachment
+	for i, p := range paths {
+		var alias string
+		if i < len(aliases) {
+			alias = aliases[i]
+		}
+		list = append(list, FileAttachment(p, alias))
+	}
+	if src, ok := msg[attachmentSourcesParam].([]Attachment); ok {
+		list = append(list, src...)
+	}
+	return list
+}
+
+// closeAttachments closes attachment sources of the messages.
+func closeAttachments(batch []*NotifMessage) {
+	for _, m := range batch {
+		if m == nil {
+			continue
+		}
+		src, _ := m.Message[PROV_EMAIL][attachmentSourcesParam].([]Attachment)
+		for _, a := range src {
+			a.Close()
+		}
+	}
+}
+
+// stringList returns string slice parameter, messages read from
+// the outbox have []any instead of []string.
+func stringList(v any) []string {
+	switch l := v.(type) {
+	case []string:
+		return l
+	case []any:
+		res := make([]string, 0, len(l))
+		for _, s := range l {
+			if str, ok := s.(string); ok {
+				res = append(res, str)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// copyAttachment copies the attachment to w and closes its reader.
+func copyAttachment(ctx context.Context, w io.Writer, a Attachment) error {
+	r, err := a.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("attachment %s: %v", a.Name, err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("attachment %s: %v", a.Name, err)
+	}
+	return ctx.Err()
+}
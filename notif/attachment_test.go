@@ -0,0 +1,33 @@
+package notif
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestSendContextClosesUnsentAttachments(t *testing.T) {
+	r := &closeRecorder{Reader: strings.NewReader("data")}
+	em := &EmailMessage{ToAddr: "not an address", Sources: []Attachment{ReaderAttachment("a.txt", r)}}
+
+	resp, err := NewNotifier("", "", "").Send([]*NotifMessage{em.NewNotif("test")})
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	if resp[0].Error == "" {
+		t.Fatal("invalid message is sent")
+	}
+	if !r.closed {
+		t.Error("attachment reader of the unsent message is not closed")
+	}
+}
@@ -0,0 +1,75 @@
+package notif
+
+import "fmt"
+
+// Builder builds a multi-provider message from typed provider messages.
+// Phone numbers are normalized to E.164 with the builder country.
+// Providers are used in the order of adding:
+//
+//	msg, err := notif.NewBuilder("confirm").
+//		Email(&notif.EmailMessage{ToAddr: addr, Subject: subj, Body: body}).
+//		SMS(&notif.SMSMessage{Tel: tel, Text: text}).
+//		Build()
+type Builder struct {
+	messageType string
+	country     string
+	msgs        []ProviderMessage
+}
+
+func NewBuilder(messageType string) *Builder {
+	return &Builder{messageType: messageType}
+}
+
+// Country sets the country of national phone numbers, DefaultCountry by default.
+func (b *Builder) Country(country string) *Builder {
+	b.country = country
+	return b
+}
+
+func (b *Builder) Email(msg *EmailMessage) *Builder { return b.Add(msg) }
+func (b *Builder) SMS(msg *SMSMessage) *Builder     { return b.Add(msg) }
+func (b *Builder) TM(msg *TMMessage) *Builder       { return b.Add(msg) }
+func (b *Builder) WA(msg *WAMessage) *Builder       { return b.Add(msg) }
+func (b *Builder) VB(msg *VBMessage) *Builder       { return b.Add(msg) }
+
+// Add adds a provider message, a message of the same provider replaces the previous one.
+func (b *Builder) Add(msg ProviderMessage) *Builder {
+	for i, m := range b.msgs {
+		if m.Provider() == msg.Provider() {
+			b.msgs[i] = msg
+			return b
+		}
+	}
+	b.msgs = append(b.msgs, msg)
+	return b
+}
+
+// Build validates provider messages and returns the message.
+func (b *Builder) Build() (*NotifMessage, error) {
+	return newMessage(b.messageType, b.country, b.msgs)
+}
+
+// NewMessage validates provider messages and returns one message
+// with providers in the given order of priority. Phone numbers are
+// normalized to E.164 with DefaultCountry.
+func NewMessage(messageType string, msgs ...ProviderMessage) (*NotifMessage, error) {
+	return newMessage(messageType, DefaultCountry, msgs)
+}
+
+func newMessage(messageType, country string, msgs []ProviderMessage) (*NotifMessage, error) {
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("NewMessage(): no provider messages")
+	}
+	m := NewNotifMessage(messageType)
+	for _, msg := range msgs {
+		if err := normalizeTels(country, msg); err != nil {
+			return nil, fmt.Errorf("%s: %w", msg.Provider(), err)
+		}
+		if err := msg.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", msg.Provider(), err)
+		}
+		m.AddMessage(msg.Provider())
+		msg.SetParams(m)
+	}
+	return m, nil
+}
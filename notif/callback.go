@@ -0,0 +1,45 @@
+package notif
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StatusReport is a delivery status of an out message sent by the gateway
+// or a provider to the application callback URL.
+// The body is signed with the application callback key, see SignTimestamp.
+type StatusReport struct {
+	ID         int        `json:"id"` // out message ID
+	Status     OutStatus  `json:"status"`
+	Provider   string     `json:"provider"`
+	Error      string     `json:"error"`
+	ReportedAt *time.Time `json:"reported_at"`
+}
+
+// ParseStatusReports decodes a single report or an array of reports.
+func ParseStatusReports(body []byte) ([]StatusReport, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, fmt.Errorf("empty status report")
+	}
+	var list []StatusReport
+	if body[0] == '[' {
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+	} else {
+		r := StatusReport{}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	for _, r := range list {
+		if r.ID == 0 {
+			return nil, fmt.Errorf("status report without message id")
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,237 @@
+package notif
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStub accepts one SMTP session and returns the DATA content,
+// the channel is closed when the session ends.
+func smtpStub(t *testing.T) (host string, port int, data <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan string, 1)
+	go func() {
+		defer close(ch)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 stub ready")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				b, err := io.ReadAll(tp.DotReader())
+				if err != nil {
+					return
+				}
+				ch <- string(b)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestSMTPProviderSend(t *testing.T) {
+	host, port, data := smtpStub(t)
+	p := &SMTPProvider{Host: host, Port: port, FromAddr: "noreply@example.com", FromName: "Robot"}
+
+	em := &EmailMessage{
+		ToAddr:    "user@example.com",
+		ToName:    "User",
+		ReplyName: "Support <support@example.com>",
+		Subject:   "Test",
+		Body:      "hello",
+		Sources:   []Attachment{BytesAttachment("a.txt", []byte("attachment"))},
+	}
+	if err := p.SendEmail(context.Background(), em); err != nil {
+		t.Fatalf("SendEmail(): %v", err)
+	}
+
+	msg := <-data
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(msg)))
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("ReadMIMEHeader(): %v", err)
+	}
+	for k, v := range map[string]string{
+		"From":     `"Robot" <noreply@example.com>`,
+		"To":       `"User" <user@example.com>`,
+		"Reply-To": `"Support" <support@example.com>`,
+		"Subject":  "Test",
+	} {
+		if got := header.Get(k); got != v {
+			t.Errorf("header %s = %q, want %q", k, got, v)
+		}
+	}
+	if !strings.HasPrefix(header.Get("Content-Type"), "multipart/mixed") {
+		t.Errorf("unexpected Content-Type: %s", header.Get("Content-Type"))
+	}
+	if !strings.Contains(msg, `filename=a.txt`) {
+		t.Error("attachment is not found in the message")
+	}
+}
+
+// failingReader returns some data and then an error.
+type failingReader struct {
+	n int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n > 0 {
+		return 0, errors.New("read failed")
+	}
+	r.n = copy(p, "partial content")
+	return r.n, nil
+}
+
+func TestSMTPProviderAttachmentError(t *testing.T) {
+	for name, att := range map[string]Attachment{
+		"open": FileAttachment("/nonexistent/file.txt", ""),
+		"read": ReaderAttachment("a.txt", &failingReader{}),
+	} {
+		host, port, data := smtpStub(t)
+		p := &SMTPProvider{Host: host, Port: port, FromAddr: "noreply@example.com"}
+		em := &EmailMessage{ToAddr: "user@example.com", Subject: "Test", Body: "hello", Sources: []Attachment{att}}
+		if err := p.SendEmail(context.Background(), em); err == nil {
+			t.Errorf("%s: expected attachment error", name)
+		}
+		if msg, ok := <-data; ok {
+			t.Errorf("%s: truncated message is accepted by the server: %q", name, msg)
+		}
+	}
+}
+
+func TestSMTPProviderHeaderInjection(t *testing.T) {
+	p := &SMTPProvider{Host: "127.0.0.1", Port: 1, FromAddr: "noreply@example.com"}
+	for _, em := range []*EmailMessage{
+		{ToAddr: "user@example.com", Subject: "Test\r\nBcc: victim@example.com"},
+		{ToAddr: "user@example.com", ReplyName: "a@example.com\nBcc: victim@example.com"},
+		{ToAddr: "user@example.com", ToName: "User\r\nX-Injected: 1"},
+	} {
+		if err := p.SendEmail(context.Background(), em); err == nil || !strings.Contains(err.Error(), "перевод строки") {
+			t.Errorf("expected header newline error, got %v", err)
+		}
+	}
+}
+
+func TestTelegramProviderSend(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/botTOKEN/sendMessage" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if got["chat_id"] == "0" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"ok":false,"description":"chat not found"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	p := &TelegramProvider{Token: "TOKEN", APIURL: srv.URL}
+	msg := map[string]any{"chat_id": "123", "text": "hello"}
+	if err := p.Send(context.Background(), "test", PROV_TM, msg); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	if got["chat_id"] != "123" || got["text"] != "hello" {
+		t.Errorf("unexpected request body: %v", got)
+	}
+
+	msg["chat_id"] = "0"
+	err := p.Send(context.Background(), "test", PROV_TM, msg)
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("expected telegram error, got %v", err)
+	}
+}
+
+func TestWebhookProviderSend(t *testing.T) {
+	const secret = "secret"
+	var got WebhookPayload
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Test") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !VerifyTimestampSignature(secret, body, r.Header.Get(WebhookSignatureHeader), r.Header.Get(WebhookTimestampHeader), 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	p := &WebhookProvider{URL: srv.URL, Secret: secret, Headers: map[string]string{"X-Test": "1"}}
+	if err := p.Send(context.Background(), "test", PROV_EMAIL, map[string]any{"to_addr": "user@example.com"}); err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	if got.MessageType != "test" || got.Provider != PROV_EMAIL || got.Message["to_addr"] != "user@example.com" {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+
+	status = http.StatusInternalServerError
+	err := p.Send(context.Background(), "test", PROV_EMAIL, map[string]any{})
+	if err == nil || !strings.Contains(err.Error(), strconv.Itoa(status)) {
+		t.Errorf("expected http code error, got %v", err)
+	}
+}
+
+func TestVerifyTimestampSignature(t *testing.T) {
+	const secret = "secret"
+	body := []byte(`{"id":1,"status":4}`)
+	now := time.Now().Unix()
+
+	if !VerifyTimestampSignature(secret, body, SignTimestamp(secret, now, body), strconv.FormatInt(now, 10), 0) {
+		t.Error("valid signature is rejected")
+	}
+	stale := now - int64(DefSignatureMaxAge.Seconds()) - 60
+	if VerifyTimestampSignature(secret, body, SignTimestamp(secret, stale, body), strconv.FormatInt(stale, 10), 0) {
+		t.Error("stale timestamp is accepted")
+	}
+	if VerifyTimestampSignature(secret, body, SignTimestamp(secret, now, body), strconv.FormatInt(now+1, 10), 0) {
+		t.Error("signature of another timestamp is accepted")
+	}
+}
@@ -0,0 +1,187 @@
+package notif
+
+import (
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+const defGatewayTimeout = time.Duration(5) * time.Minute
+
+// GatewayProvider sends messages to the external notification gateway
+// which delivers them with its own providers.
+type GatewayProvider struct {
+	Host    string
+	AppName string        // login
+	Pwd     string        // password
+	Timeout time.Duration // max duration of one request, 5 minutes if empty
+	Client  *http.Client  // http.DefaultClient if nil
+}
+
+// Send sends one provider part of the message through the gateway.
+func (g *GatewayProvider) Send(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+	m := NotifMessage{
+		MessageType: messageType,
+		Providers:   []NotifProvider{prov},
+		Message:     map[NotifProvider]map[string]any{prov: msg},
+	}
+	resp, err := g.SendBatch(ctx, []*NotifMessage{&m})
+	if err != nil {
+		return err
+	}
+	if len(resp) > 0 && resp[0].Error != "" {
+		return errors.New(resp[0].Error)
+	}
+	return nil
+}
+
+// gatewayBatch returns messages for the gateway and their attachments.
+// Email attachments are replaced with file names, files are sent
+// as form fields in the same order. Batch messages are not modified.
+func gatewayBatch(batch []*NotifMessage) ([]*NotifMessage, []Attachment) {
+	var files []Attachment
+	res := make([]*NotifMessage, len(batch))
+	for i, m := range batch {
+		res[i] = m
+		provMsg, ok := m.Message[PROV_EMAIL]
+		if !ok {
+			continue
+		}
+		atts := emailAttachments(provMsg)
+		if len(atts) == 0 {
+			continue
+		}
+
+		emailMsg := make(map[string]any, len(provMsg))
+		for k, v := range provMsg {
+			emailMsg[k] = v
+		}
+		delete(emailMsg, "attachment_alias")
+		delete(emailMsg, attachmentSourcesParam)
+		names := make([]string, len(atts))
+		for j, a := range atts {
+			names[j] = a.Name
+		}
+		emailMsg["attachments"] = names
+
+		msgCopy := *m
+		msgCopy.Message = make(map[NotifProvider]map[string]any, len(m.Message))
+		for prov, pm := range m.Message {
+			msgCopy.Message[prov] = pm
+		}
+		msgCopy.Message[PROV_EMAIL] = emailMsg
+		res[i] = &msgCopy
+
+		files = append(files, atts...)
+	}
+	return res, files
+}
+
+// SendBatch posts the batch to the gateway. Without attachments the batch
+// is sent as JSON, otherwise as a multipart form with messages field and
+// fileN fields, the form is streamed, attachments are not loaded into memory.
+func (g *GatewayProvider) SendBatch(ctx context.Context, batch []*NotifMessage) ([]*Response, error) {
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = defGatewayTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	gwBatch, files := gatewayBatch(batch)
+	jsonB, err := json.Marshal(gwBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	var contType string
+	writeDone := make(chan error, 1)
+	if len(files) == 0 {
+		body = bytes.NewReader(jsonB)
+		contType = "application/json"
+		writeDone <- nil
+
+	} else {
+		pr, pw := io.Pipe()
+		defer pr.Close() // unblocks the writer if the request fails
+		mw := multipart.NewWriter(pw)
+		body = pr
+		contType = mw.FormDataContentType() // this will contain the boundary
+
+		go func() {
+			err := writeGatewayForm(ctx, mw, jsonB, files)
+			pw.CloseWithError(err)
+			writeDone <- err
+		}()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.Host, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization",
+		fmt.Sprintf("Basic %s", b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", g.AppName, g.Pwd)))))
+	req.Header.Set("Content-Type", contType)
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// form errors are more informative than the broken pipe
+		select {
+		case wErr := <-writeDone:
+			if wErr != nil {
+				return nil, wErr
+			}
+		default:
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error http code: %d", resp.StatusCode)
+	}
+
+	respList := make([]*Response, 0)
+	if err := json.Unmarshal(respBody, &respList); err != nil {
+		return nil, err
+	}
+
+	return respList, nil
+}
+
+// writeGatewayForm writes messages field and attachments, every attachment
+// is closed right after it is copied.
+func writeGatewayForm(ctx context.Context, mw *multipart.Writer, jsonB []byte, files []Attachment) error {
+	fw, err := mw.CreateFormField("messages")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(jsonB); err != nil {
+		return err
+	}
+	for i, a := range files {
+		fw, err := mw.CreateFormFile(fmt.Sprintf("file%d", i), a.Name)
+		if err != nil {
+			return err
+		}
+		if err := copyAttachment(ctx, fw, a); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
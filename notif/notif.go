@@ -1,17 +1,11 @@
 package notif
 
 import (
-	"bytes"
 	"context"
-	b64 "encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"mime/multipart"
-	"net/http"
-	"os"
 	"strings"
+	"sync"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -100,6 +94,31 @@ func (msg *SMSMessage) SetParams(notifMsg *NotifMessage) {
 	notifMsg.Message[PROV_SMS]["text"] = msg.Text
 }
 
+// --------------------------------------------------------------------------------------------------
+// VBMessage is a Viber provider message
+type VBMessage struct {
+	Tel  string `json:"tel"`
+	Text string `json:"text"`
+}
+
+// NewNotif returns new notif message with one provider
+func (msg *VBMessage) NewNotif(messageType string) *NotifMessage {
+	new_m := NotifMessage{
+		MessageType: messageType,
+		Providers:   []NotifProvider{PROV_VB},
+		Message:     make(map[NotifProvider]map[string]any),
+	}
+	msg.SetParams(&new_m)
+	return &new_m
+}
+
+// SetParams sets NotifMessage parameters from VBMessage structure
+func (msg *VBMessage) SetParams(notifMsg *NotifMessage) {
+	notifMsg.Message[PROV_VB] = make(map[string]any)
+	notifMsg.Message[PROV_VB]["tel"] = msg.Tel
+	notifMsg.Message[PROV_VB]["text"] = msg.Text
+}
+
 // --------------------------------------------------------------------------------------------------
 // SMSMessage is an EmailMessage provider message
 type EmailMessage struct {
@@ -111,9 +130,10 @@ type EmailMessage struct {
 	Body            string   `json:"body"`
 	SenderAddr      string   `json:"sender_addr"`
 	Subject         string   `json:"subject"`
-	Attachments     []string `json:"attachments"` // pathes to attachments
-	AttachmentAlias []string // Aliases for file names, same Len as Attachments, no paths
+	Attachments     []string `json:"attachments"`      // pathes to attachments
+	AttachmentAlias []string `json:"attachment_alias"` // Aliases for file names, same Len as Attachments, no paths
 	// Will not be sent to server
+	Sources []Attachment `json:"-"` // attachments from readers or database, sent after Attachments
 }
 
 // NewNotif returns new notif message with email provider
@@ -140,12 +160,17 @@ func (msg *EmailMessage) SetParams(notifMsg *NotifMessage) {
 	notifMsg.Message[PROV_EMAIL]["subject"] = msg.Subject
 	notifMsg.Message[PROV_EMAIL]["attachments"] = msg.Attachments
 	notifMsg.Message[PROV_EMAIL]["attachment_alias"] = msg.AttachmentAlias
+	if len(msg.Sources) > 0 {
+		notifMsg.Message[PROV_EMAIL][attachmentSourcesParam] = msg.Sources
+	}
 }
 
 type NotifMessage struct {
 	MessageType string                           `json:"messageType"` // application defined message type, arbitary string
 	Providers   []NotifProvider                  `json:"providers"`   // list of providers for the message in order of priority
 	Message     map[NotifProvider]map[string]any `json:"message"`     // message, specific structure for every provider
+	RecipientID string                           `json:"-"`           // recipient for preferences, not sent to the gateway
+	DedupKey    string                           `json:"-"`           // outbox suppresses messages with the same key within Policy.DedupWindow
 }
 
 func (nm *NotifMessage) AddMessage(prov NotifProvider) {
@@ -178,12 +203,28 @@ type Response struct {
 	Error string `json:"error"`
 }
 
+// Notifier sends messages with registered provider drivers.
+// Host, AppName and Pwd configure the notification gateway used for
+// providers without drivers.
 type Notifier struct {
 	AppName string `json:"appName"` // login
 	Pwd     string `json:"pwd"`     // password
 	Host    string `json:"host"`
+
+	// Prefs applies recipient preferences before sending if set.
+	Prefs *PrefsFilter `json:"-"`
+	// Defer stores a message falling in quiet hours or subject to the delivery
+	// policy for later delivery, without it such messages get an error response.
+	Defer func(ctx context.Context, m *NotifMessage) error `json:"-"`
+	// Policed returns true for messages the outbox Policy applies to,
+	// they are passed to Defer instead of being sent directly.
+	Policed func(m *NotifMessage) bool `json:"-"`
+
+	mx      sync.RWMutex
+	drivers map[NotifProvider]Provider
 }
 
+// NewNotifier returns a notifier sending all messages through the gateway.
 func NewNotifier(host, appName, pwd string) *Notifier {
 	return &Notifier{
 		AppName: appName,
@@ -192,131 +233,13 @@ func NewNotifier(host, appName, pwd string) *Notifier {
 	}
 }
 
-type fileForSending struct {
-	fileName string // path to file
-	alias    string
-}
-
-func (n *Notifier) Send(batch []*NotifMessage) ([]*Response, error) {
-	var files []fileForSending // actual files with alias
-	// check attachments: provider=email + param=attachments
-	for i, m := range batch {
-		if m.Message == nil {
-			continue
-		}
-		prov_msg, ok := m.Message[PROV_EMAIL]
-		if !ok {
-			continue
-		}
-		att_i, ok := prov_msg["attachments"]
-		if !ok {
-			continue
-		}
-		att_list, ok := att_i.([]string)
-		if !ok {
-			continue
-		}
-		if files == nil {
-			files = make([]fileForSending, 0)
-		}
-		var att_alias_list []string
-		att_alias_i, ok := prov_msg["attachment_alias"]
-		if ok {
-			att_alias_list, _ = att_alias_i.([]string)
-		}
-		for att_ind, att_s := range att_list {
-			att := fileForSending{fileName: att_s}
-			if att_alias_list != nil && len(att_alias_list) > att_ind {
-				att.alias = att_alias_list[att_ind]
-			}
-			files = append(files, att)
-		}
-		if m.Message[PROV_EMAIL]["attachment_alias"] != nil {
-			batch[i].Message[PROV_EMAIL]["attachments"] = m.Message[PROV_EMAIL]["attachment_alias"]
-			delete(batch[i].Message[PROV_EMAIL], "attachment_alias")
-		}
-	}
-
-	json_b, err := json.Marshal(&batch)
-	if err != nil {
-		return nil, err
-	}
-	var b bytes.Buffer
-	var cont_type string
-	if len(files) == 0 {
-		// no attachments, content-type=application/json
-		b = *bytes.NewBuffer(json_b)
-		cont_type = "application/json"
-
-	} else {
-		// attachments, multipart form
-		// messages=[]NotifMessage, fileN...
-		w := multipart.NewWriter(&b)
-		fw, err := w.CreateFormField("messages")
-		if err != nil {
-			return nil, err
-		}
-		if _, err = io.Copy(fw, bytes.NewReader(json_b)); err != nil {
-			return nil, err
-		}
-		for i, fl := range files {
-			f, err := os.Open(fl.fileName)
-			if err != nil {
-				return nil, err
-			}
-			var file_name string
-			if fl.alias != "" {
-				file_name = fl.alias
-			} else {
-				file_name = f.Name() // original name
-			}
-			if fw, err = w.CreateFormFile(fmt.Sprintf("file%d", i), file_name); err != nil {
-				return nil, err
-			}
-			if _, err = io.Copy(fw, f); err != nil {
-				return nil, err
-			}
-		}
-		w.Close()
-		cont_type = w.FormDataContentType() // this will contain the boundary
-	}
-
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", n.Host, &b)
-	if err != nil {
-		return nil, err
-	}
-	fmt.Println("sending headers:", b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", n.AppName, n.Pwd))))
-	fmt.Println("body:", b.String())
-	req.Header.Set("Authorization",
-		fmt.Sprintf("Basic %s", b64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", n.AppName, n.Pwd)))))
-	req.Header.Set("Content-Type", cont_type)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("error http code: %d", resp.StatusCode)
-	}
-
-	resp_body := make([]*Response, 0)
-	if err := json.Unmarshal(body, &resp_body); err != nil {
-		return nil, err
-	}
-
-	return resp_body, nil
-}
-
 // ----------------------------------------------------------------------------------
 // SQL function can accept any namber of parameters and must have this signature:
 //
 //	tel text
 //	body text
+//
+// tel is normalized to E.164 with DefaultCountry.
 func NewWAMessageFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any) (*WAMessage, error) {
 	var sql_params strings.Builder
 	for i := 0; i < len(sqlParamValues); i++ {
@@ -340,9 +263,11 @@ func NewWAMessageFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any) (
 		return nil, errors.New(ER_TEMPLATE_NOT_FOUND)
 	}
 
-	if msg.Tel == "" {
-		return nil, errors.New(ER_NO_TEL)
+	tel, err := NormalizeTel(msg.Tel, DefaultCountry)
+	if err != nil {
+		return nil, err
 	}
+	msg.Tel = tel
 	return msg, nil
 }
 
@@ -369,6 +294,12 @@ func NewWAMessagesFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any)
 		if err := rows.Scan(&msg.Tel, &msg.Text); err != nil {
 			return nil, err
 		}
+		tel, err := NormalizeTel(msg.Tel, DefaultCountry)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		msg.Tel = tel
 		msg_list = append(msg_list, &msg)
 	}
 	if err := rows.Err(); err != nil {
@@ -450,6 +381,8 @@ func NewTMMessagesFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any)
 //
 //	tel text
 //	body text
+//
+// tel is normalized to E.164 with DefaultCountry.
 func NewSMSMessageFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any) (*SMSMessage, error) {
 	var sql_params strings.Builder
 	for i := 0; i < len(sqlParamValues); i++ {
@@ -473,9 +406,48 @@ func NewSMSMessageFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any)
 		return nil, errors.New(ER_TEMPLATE_NOT_FOUND)
 	}
 
-	if msg.Tel == "" {
-		return nil, errors.New(ER_NO_TEL)
+	tel, err := NormalizeTel(msg.Tel, DefaultCountry)
+	if err != nil {
+		return nil, err
+	}
+	msg.Tel = tel
+	return msg, nil
+}
+
+// SQL function can accept any namber of parameters and must have this signature:
+//
+//	tel text
+//	body text
+//
+// tel is normalized to E.164 with DefaultCountry.
+func NewVBMessageFromSQL(conn *pgx.Conn, sqlFunc string, sqlParamValues []any) (*VBMessage, error) {
+	var sql_params strings.Builder
+	for i := 0; i < len(sqlParamValues); i++ {
+		if i > 0 {
+			sql_params.WriteString(",")
+		}
+		sql_params.WriteString(fmt.Sprintf("$%d", i+1))
+	}
+	msg := &VBMessage{}
+	if err := conn.QueryRow(context.Background(),
+		fmt.Sprintf(`SELECT
+			tel,
+			body
+		FROM %s(%s)`, sqlFunc, sql_params.String()),
+		sqlParamValues...).Scan(
+		&msg.Tel,
+		&msg.Text,
+	); err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("SELECT from %s, with args: %v failed: %v", sqlFunc, sql_params.String(), err)
+	} else if err == pgx.ErrNoRows {
+		return nil, errors.New(ER_TEMPLATE_NOT_FOUND)
+	}
+
+	tel, err := NormalizeTel(msg.Tel, DefaultCountry)
+	if err != nil {
+		return nil, err
 	}
+	msg.Tel = tel
 	return msg, nil
 }
 
@@ -0,0 +1,56 @@
+package notiftest
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var pageTmpl = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>Notification sandbox</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 20px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+pre { margin: 0; white-space: pre-wrap; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Notification sandbox</h1>
+<p>{{len .Messages}} messages.
+<button onclick="fetch('api/messages', {method: 'DELETE'}).then(() => location.reload())">Clear</button>
+<a href="api/messages">JSON</a> <a href="api/failures">Failures</a></p>
+<table>
+<tr><th>ID</th><th>Received</th><th>Type</th><th>Providers</th><th>Message</th><th>Files</th><th>Error</th></tr>
+{{range .Messages}}{{$id := .ID}}
+<tr>
+<td><a href="api/messages/{{.ID}}">{{.ID}}</a></td>
+<td>{{.ReceivedAt.Format "2006-01-02 15:04:05"}}</td>
+<td>{{.MessageType}}</td>
+<td>{{range .Providers}}{{.}} {{end}}</td>
+<td>{{range $prov, $params := .Message}}<b>{{$prov}}</b><pre>{{range $k, $v := $params}}{{$k}}: {{$v}}
+{{end}}</pre>{{end}}</td>
+<td>{{range $i, $f := .Files}}<a href="api/messages/{{$id}}/files/{{$i}}">{{$f.Name}}</a> ({{$f.Size}})<br>{{end}}</td>
+<td class="error">{{.Error}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+func (s *Sandbox) handlePage(w http.ResponseWriter, r *http.Request) {
+	list := s.Messages()
+	// newest first
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTmpl.Execute(w, map[string]any{"Messages": list}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,401 @@
+// Package notiftest provides a local notification gateway for development
+// and tests, so messages can be sent and inspected without the real gateway.
+package notiftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dronm/gobizapp/notif"
+)
+
+const maxFormMemory = 32 << 20
+
+// File is an attachment received with a message.
+type File struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Data        []byte `json:"-"`
+}
+
+// Message is a received message.
+type Message struct {
+	ID         int       `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	AppName    string    `json:"app_name"`
+	notif.NotifMessage
+	Files []File `json:"files"`
+	Error string `json:"error"` // injected error returned to the sender
+}
+
+// Failures configures injected errors.
+type Failures struct {
+	HTTPStatus  int                            `json:"http_status"` // whole batches are answered with this status
+	HTTPCount   int                            `json:"http_count"`  // number of batches failed with HTTPStatus, all if 0
+	Next        int                            `json:"next"`        // number of next messages answered with Error
+	Rate        float64                        `json:"rate"`        // share of messages answered with Error, 0..1
+	Error       string                         `json:"error"`       // error text for Next and Rate
+	Types       map[string]string              `json:"types"`       // error text by message type
+	Providers   map[notif.NotifProvider]string `json:"providers"`   // error text by the first message provider
+	DelayMs     int                            `json:"delay_ms"`    // pause before every batch response
+	Unavailable bool                           `json:"unavailable"` // connections are closed without a response
+}
+
+// Sandbox implements the gateway protocol: POST of a JSON batch or
+// a multipart form with messages field and fileN fields, Basic auth,
+// []notif.Response result. Received messages are kept in memory.
+// GET / shows the messages page, the API is:
+//
+//	GET    /api/messages               messages, ?type= filters by message type
+//	GET    /api/messages/{id}          one message
+//	GET    /api/messages/{id}/files/{n} attachment content
+//	DELETE /api/messages               clears messages
+//	GET    /api/failures               injected failures
+//	PUT    /api/failures               sets injected failures
+//
+// The sandbox can be run for development with http.ListenAndServe(addr, sandbox).
+type Sandbox struct {
+	AppName string // credentials checked if AppName is set
+	Pwd     string
+
+	mx       sync.Mutex
+	messages []*Message
+	nextID   int
+	failures Failures
+	received chan struct{} // closed and replaced on every batch
+	rnd      *rand.Rand
+	mux      *http.ServeMux
+}
+
+func NewSandbox(appName, pwd string) *Sandbox {
+	s := &Sandbox{
+		AppName:  appName,
+		Pwd:      pwd,
+		received: make(chan struct{}),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /{$}", s.handleGateway)
+	s.mux.HandleFunc("GET /{$}", s.handlePage)
+	s.mux.HandleFunc("GET /api/messages", s.handleMessages)
+	s.mux.HandleFunc("DELETE /api/messages", s.handleReset)
+	s.mux.HandleFunc("GET /api/messages/{id}", s.handleMessage)
+	s.mux.HandleFunc("GET /api/messages/{id}/files/{n}", s.handleFile)
+	s.mux.HandleFunc("GET /api/failures", s.handleGetFailures)
+	s.mux.HandleFunc("PUT /api/failures", s.handleSetFailures)
+	return s
+}
+
+func (s *Sandbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Messages returns received messages in order of receiving.
+func (s *Sandbox) Messages() []*Message {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]*Message(nil), s.messages...)
+}
+
+// Message returns the message by ID or nil.
+func (s *Sandbox) Message(id int) *Message {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, m := range s.messages {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// Reset removes received messages and injected failures.
+func (s *Sandbox) Reset() {
+	s.mx.Lock()
+	s.messages = nil
+	s.failures = Failures{}
+	s.mx.Unlock()
+}
+
+// SetFailures replaces injected failures.
+func (s *Sandbox) SetFailures(f Failures) {
+	s.mx.Lock()
+	s.failures = f
+	s.mx.Unlock()
+}
+
+// Failures returns injected failures, Next and HTTPCount are decreased
+// as failures are used.
+func (s *Sandbox) Failures() Failures {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.failures
+}
+
+// FailNext makes the next n messages fail with errText.
+func (s *Sandbox) FailNext(n int, errText string) {
+	s.mx.Lock()
+	s.failures.Next, s.failures.Error = n, errText
+	s.mx.Unlock()
+}
+
+// WaitMessages waits until at least n messages are received
+// and returns all received messages.
+func (s *Sandbox) WaitMessages(ctx context.Context, n int) ([]*Message, error) {
+	for {
+		s.mx.Lock()
+		if len(s.messages) >= n {
+			list := append([]*Message(nil), s.messages...)
+			s.mx.Unlock()
+			return list, nil
+		}
+		received := s.received
+		s.mx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("WaitMessages(): %d of %d messages received: %v", len(s.Messages()), n, ctx.Err())
+		case <-received:
+		}
+	}
+}
+
+// batchFailure returns the HTTP status the batch fails with or 0.
+func (s *Sandbox) batchFailure() (status int, delay time.Duration, unavailable bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	f := &s.failures
+	if f.HTTPStatus != 0 {
+		status = f.HTTPStatus
+		if f.HTTPCount > 0 {
+			if f.HTTPCount--; f.HTTPCount == 0 {
+				f.HTTPStatus = 0
+			}
+		}
+	}
+	return status, time.Duration(f.DelayMs) * time.Millisecond, f.Unavailable
+}
+
+// messageFailure returns the injected error of the message, the lock must be held.
+func (s *Sandbox) messageFailure(m *notif.NotifMessage) string {
+	f := &s.failures
+	if e, ok := f.Types[m.MessageType]; ok {
+		return e
+	}
+	if len(m.Providers) > 0 {
+		if e, ok := f.Providers[m.Providers[0]]; ok {
+			return e
+		}
+	}
+	errText := f.Error
+	if errText == "" {
+		errText = "sandbox: injected failure"
+	}
+	if f.Next > 0 {
+		f.Next--
+		return errText
+	}
+	if f.Rate > 0 && s.rnd.Float64() < f.Rate {
+		return errText
+	}
+	return ""
+}
+
+func (s *Sandbox) handleGateway(w http.ResponseWriter, r *http.Request) {
+	if s.AppName != "" {
+		user, pwd, ok := r.BasicAuth()
+		if !ok || user != s.AppName || pwd != s.Pwd {
+			w.Header().Set("WWW-Authenticate", `Basic realm="notif sandbox"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	status, delay, unavailable := s.batchFailure()
+	if delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+	if unavailable {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		status = http.StatusServiceUnavailable
+	}
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	batch, files, err := readBatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	appName, _, _ := r.BasicAuth()
+
+	s.mx.Lock()
+	resp := make([]*notif.Response, len(batch))
+	for i, m := range batch {
+		s.nextID++
+		msg := &Message{
+			ID:           s.nextID,
+			ReceivedAt:   time.Now(),
+			AppName:      appName,
+			NotifMessage: *m,
+			Error:        s.messageFailure(m),
+		}
+		// files are sent in order of email attachments
+		if email, ok := m.Message[notif.PROV_EMAIL]; ok {
+			if names, ok := email["attachments"].([]any); ok {
+				n := min(len(names), len(files))
+				msg.Files, files = files[:n], files[n:]
+			}
+		}
+		s.messages = append(s.messages, msg)
+		resp[i] = &notif.Response{ID: msg.ID, Error: msg.Error}
+	}
+	close(s.received)
+	s.received = make(chan struct{})
+	s.mx.Unlock()
+
+	writeJSON(w, resp)
+}
+
+// readBatch reads a JSON batch or a multipart form with attachments.
+func readBatch(r *http.Request) ([]*notif.NotifMessage, []File, error) {
+	var batch []*notif.NotifMessage
+	var files []File
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			return nil, nil, fmt.Errorf("json: %v", err)
+		}
+		return batch, nil, nil
+	}
+
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+		return nil, nil, fmt.Errorf("multipart form: %v", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+	if err := json.Unmarshal([]byte(r.FormValue("messages")), &batch); err != nil {
+		return nil, nil, fmt.Errorf("messages field: %v", err)
+	}
+	for i := 0; ; i++ {
+		fhs := r.MultipartForm.File["file"+strconv.Itoa(i)]
+		if len(fhs) == 0 {
+			break
+		}
+		f, err := fhs[0].Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("file%d: %v", i, err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("file%d: %v", i, err)
+		}
+		files = append(files, File{
+			Name:        fhs[0].Filename,
+			ContentType: fhs[0].Header.Get("Content-Type"),
+			Size:        len(data),
+			Data:        data,
+		})
+	}
+	return batch, files, nil
+}
+
+func (s *Sandbox) handleMessages(w http.ResponseWriter, r *http.Request) {
+	list := s.Messages()
+	if mt := r.URL.Query().Get("type"); mt != "" {
+		filtered := make([]*Message, 0, len(list))
+		for _, m := range list {
+			if m.MessageType == mt {
+				filtered = append(filtered, m)
+			}
+		}
+		list = filtered
+	}
+	if list == nil {
+		list = []*Message{}
+	}
+	writeJSON(w, list)
+}
+
+func (s *Sandbox) handleMessage(w http.ResponseWriter, r *http.Request) {
+	m := s.pathMessage(w, r)
+	if m == nil {
+		return
+	}
+	writeJSON(w, m)
+}
+
+func (s *Sandbox) handleFile(w http.ResponseWriter, r *http.Request) {
+	m := s.pathMessage(w, r)
+	if m == nil {
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 || n >= len(m.Files) {
+		http.NotFound(w, r)
+		return
+	}
+	f := m.Files[n]
+	if f.ContentType != "" {
+		w.Header().Set("Content-Type", f.ContentType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.Name))
+	w.Write(f.Data)
+}
+
+// pathMessage returns the message of the id path value or writes 404.
+func (s *Sandbox) pathMessage(w http.ResponseWriter, r *http.Request) *Message {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		if m := s.Message(id); m != nil {
+			return m
+		}
+	}
+	http.NotFound(w, r)
+	return nil
+}
+
+func (s *Sandbox) handleReset(w http.ResponseWriter, r *http.Request) {
+	s.mx.Lock()
+	s.messages = nil
+	s.mx.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Sandbox) handleGetFailures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Failures())
+}
+
+func (s *Sandbox) handleSetFailures(w http.ResponseWriter, r *http.Request) {
+	var f Failures
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.SetFailures(f)
+	writeJSON(w, f)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,63 @@
+package notiftest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dronm/gobizapp/notif"
+)
+
+const (
+	DefAppName = "notiftest"
+	DefPwd     = "notiftest"
+	defTimeout = time.Duration(5) * time.Second
+)
+
+// Server is a Sandbox running on a local httptest listener.
+// To send messages of the services package to it, call
+// services.InitNotifier(srv.URL, srv.AppName, srv.Pwd).
+type Server struct {
+	*Sandbox
+	HTTP *httptest.Server
+	URL  string // gateway host for notif.NewNotifier
+}
+
+// NewServer starts a sandbox with DefAppName and DefPwd credentials.
+func NewServer() *Server {
+	sb := NewSandbox(DefAppName, DefPwd)
+	srv := &Server{Sandbox: sb, HTTP: httptest.NewServer(sb)}
+	srv.URL = srv.HTTP.URL
+	return srv
+}
+
+// Start starts a server closed on the test cleanup.
+func Start(t testing.TB) *Server {
+	t.Helper()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Notifier returns a notifier sending messages to the sandbox.
+func (s *Server) Notifier() *notif.Notifier {
+	return notif.NewNotifier(s.URL, s.AppName, s.Pwd)
+}
+
+// ExpectMessages waits for n messages for 5 seconds and fails the test on timeout.
+func (s *Server) ExpectMessages(t testing.TB, n int) []*Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), defTimeout)
+	defer cancel()
+	list, err := s.WaitMessages(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// Close stops the listener.
+func (s *Server) Close() {
+	s.HTTP.Close()
+}
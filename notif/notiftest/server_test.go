@@ -0,0 +1,43 @@
+package notiftest
+
+import (
+	"testing"
+
+	"github.com/dronm/gobizapp/notif"
+)
+
+func TestNotifierRoundTrip(t *testing.T) {
+	srv := Start(t)
+
+	sms := &notif.SMSMessage{Tel: "+79161234567", Text: "hello"}
+	tm := &notif.TMMessage{ChatID: "123", Text: "hello"}
+	resp, err := srv.Notifier().Send([]*notif.NotifMessage{sms.NewNotif("test_sms"), tm.NewNotif("test_tm")})
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	if len(resp) != 2 {
+		t.Fatalf("expected 2 responses, got %d", len(resp))
+	}
+	for i, r := range resp {
+		if r.Error != "" {
+			t.Errorf("message %d: %s", i, r.Error)
+		}
+	}
+
+	list := srv.ExpectMessages(t, 2)
+	if list[0].MessageType != "test_sms" || list[0].Message[notif.PROV_SMS]["tel"] != "+79161234567" {
+		t.Errorf("unexpected sms message: %+v", list[0].NotifMessage)
+	}
+	if list[1].MessageType != "test_tm" || list[1].Message[notif.PROV_TM]["chat_id"] != "123" {
+		t.Errorf("unexpected telegram message: %+v", list[1].NotifMessage)
+	}
+
+	srv.FailNext(1, "gateway failure")
+	resp, err = srv.Notifier().Send([]*notif.NotifMessage{sms.NewNotif("test_sms")})
+	if err != nil {
+		t.Fatalf("Send(): %v", err)
+	}
+	if resp[0].Error != "gateway failure" {
+		t.Errorf("expected injected error, got %q", resp[0].Error)
+	}
+}
@@ -0,0 +1,628 @@
+package notif
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	defOutboxPollInterval = time.Duration(5) * time.Second
+	defOutboxBackoff      = time.Duration(30) * time.Second
+	defOutboxMaxBackoff   = time.Duration(1) * time.Hour
+	defOutboxSendTimeout  = time.Duration(1) * time.Minute
+	defOutboxMaxAttempts  = 10
+	defOutboxBatchSize    = 50
+	outboxLeaseProviders  = 5 // max providers per message used to calculate the lease
+
+	outMessagesRelation        = "notifications.out_messages"
+	outMessageAttemptsRelation = "notifications.out_message_attempts"
+	outMessageDedupRelation    = "notifications.out_message_dedup"
+)
+
+// OutStatus is a status of an outbox message.
+type OutStatus int
+
+const (
+	OutStatusPending    OutStatus = iota // waiting for the next delivery round
+	OutStatusSending                     // taken by a worker
+	OutStatusSent                        // delivered by one of the providers
+	OutStatusFailed                      // all attempts failed, message is closed
+	OutStatusDelivered                   // delivery confirmed by the provider callback
+	OutStatusRejected                    // rejected by the provider after sending
+	OutStatusSuppressed                  // not sent because of recipient preferences or Policy
+)
+
+// Final returns true if the message is closed with this status.
+func (s OutStatus) Final() bool {
+	return s != OutStatusPending && s != OutStatusSending
+}
+
+// DBQuerier is implemented by pgx.Conn, pgx.Tx and pgxpool.Pool,
+// it allows enqueueing messages within the caller transaction.
+type DBQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Outbox stores messages in the out_messages table and delivers them
+// in background. Every delivery round walks message providers in order of
+// priority, the first successful provider closes the message. If all providers
+// fail, the round is repeated after exponential backoff until MaxAttempts rounds
+// are done. Every provider call is written to the attempts table.
+// Attachments are sent from their paths at delivery time, files must be kept
+// until the message is closed.
+// out_messages needs attempts, next_attempt_at, last_error, provider and recipient_id columns,
+// the tables are created with database.ApplySchema(ctx, db, "notifications.sql").
+// Messages with RecipientID are checked against Notifier.Prefs when stored and
+// before every delivery round, messages in quiet hours are postponed.
+// Policy rate limits, deduplication and digests apply to outbox messages,
+// the notifier passes messages they apply to into the outbox, see Notifier.Policed.
+// They need dedup_key and digest_key columns in out_messages, recipient
+// column in the attempts table and the out_message_dedup table.
+type Outbox struct {
+	DBPool       *pgxpool.Pool
+	Notifier     *Notifier
+	AppID        int
+	PollInterval time.Duration // how often due messages are checked
+	Backoff      time.Duration // pause after the first failed round, doubled on every next one
+	MaxBackoff   time.Duration
+	SendTimeout  time.Duration // max duration of one provider call
+	MaxAttempts  int           // max number of delivery rounds
+	BatchSize    int           // max number of messages taken at once
+	Policy       *Policy       // optional delivery limits
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	cancelDone chan struct{}
+	wake       chan struct{}
+	wg         sync.WaitGroup // messages being delivered
+}
+
+func NewOutbox(dbPool *pgxpool.Pool, notifier *Notifier, appID int) *Outbox {
+	return &Outbox{
+		DBPool:       dbPool,
+		Notifier:     notifier,
+		AppID:        appID,
+		PollInterval: defOutboxPollInterval,
+		Backoff:      defOutboxBackoff,
+		MaxBackoff:   defOutboxMaxBackoff,
+		SendTimeout:  defOutboxSendTimeout,
+		MaxAttempts:  defOutboxMaxAttempts,
+		BatchSize:    defOutboxBatchSize,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Enqueue stores the batch and returns message IDs in batch order.
+// If q is nil, the pool is used, otherwise messages are inserted with q,
+// so they are delivered only if the caller transaction commits.
+func (o *Outbox) Enqueue(ctx context.Context, q DBQuerier, batch []*NotifMessage) ([]int, error) {
+	if q == nil {
+		q = o.DBPool
+	}
+	ids := make([]int, 0, len(batch))
+	for _, m := range batch {
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("Enqueue(): message type %s: %w", m.MessageType, err)
+		}
+		if _, ok := m.Message[PROV_EMAIL][attachmentSourcesParam]; ok {
+			return nil, fmt.Errorf("Enqueue(): message type %s: attachment sources can not be stored, use file paths", m.MessageType)
+		}
+		status, nextAt, reason, err := o.prefsStatus(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("Enqueue(): %v", err)
+		}
+		digestKey := o.Policy.digestKey(m)
+		if status != OutStatusSuppressed && o.Policy != nil {
+			if status, nextAt, reason, err = o.policyStatus(ctx, q, m, digestKey, nextAt); err != nil {
+				return nil, fmt.Errorf("Enqueue(): %v", err)
+			}
+		}
+		providers := make([]string, len(m.Providers))
+		for i, p := range m.Providers {
+			providers[i] = string(p)
+		}
+		msgB, err := json.Marshal(m.Message)
+		if err != nil {
+			return nil, fmt.Errorf("Enqueue() json.Marshal(): %v", err)
+		}
+		var id int
+		if err := q.QueryRow(ctx,
+			`INSERT INTO `+outMessagesRelation+`
+			(app_id, providers, message, message_type, status, closed, attempts, next_attempt_at, last_error, recipient_id,
+			dedup_key, digest_key)
+			VALUES ($1, $2, $3, $4, $5, $6, 0, coalesce($7, now()), nullif($8, ''), nullif($9, ''),
+			nullif($10, ''), nullif($11, ''))
+			RETURNING id`,
+			o.AppID, providers, msgB, m.MessageType, status, status.Final(), nextAt, reason, m.RecipientID,
+			m.DedupKey, digestKey,
+		).Scan(&id); err != nil {
+			return nil, fmt.Errorf("Enqueue() INSERT: %v", err)
+		}
+		if status != OutStatusSuppressed && m.DedupKey != "" && o.Policy != nil {
+			var dedupID int
+			if err := q.QueryRow(ctx,
+				`UPDATE `+outMessageDedupRelation+`
+				SET out_message_id = $3
+				WHERE app_id = $1 AND dedup_key = $2
+				RETURNING out_message_id`,
+				o.AppID, m.DedupKey, id,
+			).Scan(&dedupID); err != nil {
+				return nil, fmt.Errorf("Enqueue() dedup UPDATE: %v", err)
+			}
+		}
+		if status == OutStatusSuppressed {
+			logger.Logger.Infof("Outbox message %d suppressed: %s", id, reason)
+		}
+		ids = append(ids, id)
+	}
+	o.Wake()
+	return ids, nil
+}
+
+// prefsStatus returns the initial status of the message by recipient preferences,
+// time of the first attempt for quiet hours and the suppression reason.
+func (o *Outbox) prefsStatus(ctx context.Context, m *NotifMessage) (OutStatus, *time.Time, string, error) {
+	if m.RecipientID == "" || o.Notifier.Prefs == nil {
+		return OutStatusPending, nil, "", nil
+	}
+	msgCopy := *m
+	until, err := o.Notifier.Prefs.Apply(ctx, &msgCopy, time.Now())
+	if errors.Is(err, ErrUnsubscribed) || errors.Is(err, ErrNoAllowedProviders) {
+		return OutStatusSuppressed, nil, err.Error(), nil
+	} else if err != nil {
+		return 0, nil, "", err
+	}
+	if until.IsZero() {
+		return OutStatusPending, nil, "", nil
+	}
+	return OutStatusPending, &until, "", nil
+}
+
+// policyStatus suppresses duplicates of messages stored within the dedup window
+// and delays digest messages till the end of their digest window.
+func (o *Outbox) policyStatus(ctx context.Context, q DBQuerier, m *NotifMessage, digestKey string, nextAt *time.Time) (OutStatus, *time.Time, string, error) {
+	if m.DedupKey != "" {
+		claimed, dupID, err := o.claimDedup(ctx, q, m.DedupKey)
+		if err != nil {
+			return 0, nil, "", err
+		}
+		if !claimed {
+			return OutStatusSuppressed, nil, fmt.Sprintf("duplicate of %d", dupID), nil
+		}
+	}
+	if digestKey != "" {
+		// all pending messages of the digest are sent together with the first one
+		var at time.Time
+		if err := q.QueryRow(ctx,
+			`SELECT coalesce(min(next_attempt_at), now() + $3 * interval '1 millisecond')
+			FROM `+outMessagesRelation+`
+			WHERE app_id = $1 AND digest_key = $2 AND status = $4`,
+			o.AppID, digestKey, o.Policy.Digests[m.MessageType].Window.Milliseconds(), OutStatusPending,
+		).Scan(&at); err != nil {
+			return 0, nil, "", fmt.Errorf("digest SELECT: %v", err)
+		}
+		if nextAt == nil || at.After(*nextAt) {
+			nextAt = &at
+		}
+	}
+	return OutStatusPending, nextAt, "", nil
+}
+
+// claimDedup takes the dedup key for the window. The key row is inserted
+// or taken over when its window has expired in one statement, so of concurrent
+// messages with the same key only one gets it: the others wait for its
+// transaction and see the key taken. The ID of the message holding the key
+// is returned if the key is not claimed.
+func (o *Outbox) claimDedup(ctx context.Context, q DBQuerier, key string) (bool, int, error) {
+	var appID int
+	err := q.QueryRow(ctx,
+		`INSERT INTO `+outMessageDedupRelation+` AS d
+		(app_id, dedup_key, out_message_id, expires_at)
+		VALUES ($1, $2, NULL, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (app_id, dedup_key) DO UPDATE
+		SET out_message_id = NULL, expires_at = EXCLUDED.expires_at
+		WHERE d.expires_at <= now()
+		RETURNING app_id`,
+		o.AppID, key, o.Policy.dedupWindow().Milliseconds(),
+	).Scan(&appID)
+	if err == nil {
+		return true, 0, nil
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, fmt.Errorf("dedup INSERT: %v", err)
+	}
+
+	var dupID int
+	if err := q.QueryRow(ctx,
+		`SELECT coalesce(out_message_id, 0) FROM `+outMessageDedupRelation+`
+		WHERE app_id = $1 AND dedup_key = $2`,
+		o.AppID, key,
+	).Scan(&dupID); err != nil {
+		return false, 0, fmt.Errorf("dedup SELECT: %v", err)
+	}
+	return false, dupID, nil
+}
+
+// Wake makes the worker check due messages without waiting for the poll interval.
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) Serve() {
+	o.ctx, o.cancel = context.WithCancel(context.Background())
+	o.cancelDone = make(chan struct{})
+
+	go func() {
+		defer close(o.cancelDone)
+
+		logger.Logger.Infof("Outbox: started, poll interval: %v", o.PollInterval)
+
+		ticker := time.NewTicker(o.PollInterval)
+		defer ticker.Stop()
+		for {
+			if err := o.deliverDue(); err != nil && o.ctx.Err() == nil {
+				logger.Logger.Errorf("Outbox deliverDue(): %v", err)
+			}
+			select {
+			case <-o.ctx.Done():
+				return
+			case <-ticker.C:
+			case <-o.wake:
+			}
+		}
+	}()
+}
+
+func (o *Outbox) Shutdown(ctx context.Context) {
+	if o.cancel == nil {
+		return
+	}
+	logger.Logger.Debug("Outbox stopping on request...")
+	o.cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-o.cancelDone:
+	}
+
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+	logger.Logger.Info("Outbox stopped")
+}
+
+type outMessage struct {
+	id        int
+	attempts  int
+	digestKey string
+	msg       NotifMessage
+}
+
+// deliverDue takes due messages and delivers them.
+// Messages are locked with SKIP LOCKED, a taken message gets a lease
+// so that it is retried if the worker dies while sending.
+func (o *Outbox) deliverDue() error {
+	lease := o.SendTimeout*outboxLeaseProviders + o.PollInterval
+	rows, err := o.DBPool.Query(o.ctx,
+		`UPDATE `+outMessagesRelation+`
+		SET status = $1, next_attempt_at = now() + $2 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM `+outMessagesRelation+`
+			WHERE app_id = $3 AND NOT closed AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, attempts, providers, message, message_type, coalesce(recipient_id, ''), coalesce(digest_key, '')`,
+		OutStatusSending, lease.Milliseconds(), o.AppID, o.BatchSize,
+	)
+	if err != nil {
+		return fmt.Errorf("UPDATE: %v", err)
+	}
+
+	var list []*outMessage
+	for rows.Next() {
+		m := &outMessage{}
+		var providers []string
+		var msgB []byte
+		if err := rows.Scan(&m.id, &m.attempts, &providers, &msgB, &m.msg.MessageType, &m.msg.RecipientID, &m.digestKey); err != nil {
+			rows.Close()
+			return fmt.Errorf("rows.Scan(): %v", err)
+		}
+		for _, p := range providers {
+			m.msg.Providers = append(m.msg.Providers, NotifProvider(p))
+		}
+		if err := json.Unmarshal(msgB, &m.msg.Message); err != nil {
+			logger.Logger.Errorf("Outbox message %d json.Unmarshal(): %v", m.id, err)
+		}
+		list = append(list, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	list = o.mergeDigests(list)
+
+	for _, m := range list {
+		o.wg.Add(1)
+		go func(m *outMessage) {
+			defer o.wg.Done()
+			o.deliver(m)
+		}(m)
+	}
+	return nil
+}
+
+// deliver runs one delivery round of the message.
+func (o *Outbox) deliver(m *outMessage) {
+	// preferences might have changed since the message was stored
+	if m.msg.RecipientID != "" && o.Notifier.Prefs != nil {
+		until, err := o.Notifier.Prefs.Apply(o.ctx, &m.msg, time.Now())
+		switch {
+		case errors.Is(err, ErrUnsubscribed) || errors.Is(err, ErrNoAllowedProviders):
+			logger.Logger.Infof("Outbox message %d suppressed: %v", m.id, err)
+			o.close(m, OutStatusSuppressed, "", err.Error())
+			return
+		case err != nil:
+			logger.Logger.Errorf("Outbox message %d preferences: %v", m.id, err)
+		case !until.IsZero():
+			o.postpone(m, until)
+			return
+		}
+	}
+
+	m.attempts++
+
+	var lastErr string
+	var limited []string
+	var limitedUntil time.Time // the earliest time one of the limits is free
+	for _, prov := range m.msg.Providers {
+		if reason, until := o.rateLimited(m, prov); reason != "" {
+			o.storeAttempt(m, prov, errors.New(reason))
+			limited = append(limited, reason)
+			if limitedUntil.IsZero() || until.Before(limitedUntil) {
+				limitedUntil = until
+			}
+			continue
+		}
+		err := o.sendProvider(m, prov)
+		o.storeAttempt(m, prov, err)
+		if err == nil {
+			o.close(m, OutStatusSent, prov, "")
+			return
+		}
+		logger.Logger.Warnf("Outbox message %d, provider %s, attempt %d failed: %v", m.id, prov, m.attempts, err)
+		lastErr = fmt.Sprintf("%s: %v", prov, err)
+
+		if o.ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(limited) == len(m.msg.Providers) {
+		// not a failed round, the message waits for the limit window
+		logger.Logger.Infof("Outbox message %d postponed till %s: %s", m.id, limitedUntil.Format(time.RFC3339), strings.Join(limited, "; "))
+		m.attempts--
+		o.postpone(m, limitedUntil)
+		return
+	}
+
+	if m.attempts >= o.MaxAttempts {
+		logger.Logger.Errorf("Outbox message %d: all %d attempts failed, last error: %s", m.id, m.attempts, lastErr)
+		o.close(m, OutStatusFailed, "", lastErr)
+		return
+	}
+
+	backoff := o.Backoff
+	for i := 1; i < m.attempts && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, o.MaxBackoff)
+	if _, err := o.DBPool.Exec(context.Background(),
+		`UPDATE `+outMessagesRelation+`
+		SET status = $2, attempts = $3, last_error = $4,
+			next_attempt_at = now() + $5 * interval '1 millisecond'
+		WHERE id = $1`,
+		m.id, OutStatusPending, m.attempts, lastErr, backoff.Milliseconds(),
+	); err != nil {
+		logger.Logger.Errorf("Outbox message %d retry UPDATE: %v", m.id, err)
+	}
+}
+
+// postpone moves the message to the end of quiet hours or a rate limit window
+// without counting an attempt.
+func (o *Outbox) postpone(m *outMessage, until time.Time) {
+	if _, err := o.DBPool.Exec(context.Background(),
+		`UPDATE `+outMessagesRelation+`
+		SET status = $2, next_attempt_at = $3
+		WHERE id = $1`,
+		m.id, OutStatusPending, until,
+	); err != nil {
+		logger.Logger.Errorf("Outbox message %d postpone UPDATE: %v", m.id, err)
+	}
+}
+
+func (o *Outbox) sendProvider(m *outMessage, prov NotifProvider) error {
+	p := o.Notifier.driver(prov)
+	if p == nil {
+		return fmt.Errorf("no driver")
+	}
+	provMsg, ok := m.msg.Message[prov]
+	if !ok {
+		return fmt.Errorf("no message for the provider")
+	}
+	ctx, cancel := context.WithTimeout(o.ctx, o.SendTimeout)
+	defer cancel()
+
+	return p.Send(ctx, m.msg.MessageType, prov, provMsg)
+}
+
+// rateLimited returns the exceeded limit of the provider or empty string
+// with the time the limit allows the next delivery.
+// Only successful provider calls are counted.
+func (o *Outbox) rateLimited(m *outMessage, prov NotifProvider) (string, time.Time) {
+	if o.Policy == nil {
+		return "", time.Time{}
+	}
+	for _, l := range o.Policy.RateLimits {
+		var cond, key string
+		switch {
+		case l.Provider == "" && m.msg.RecipientID != "":
+			cond = "m.recipient_id = $3"
+			key = m.msg.RecipientID
+		case l.Provider == prov:
+			if key = recipientAddr(&m.msg, prov); key == "" {
+				continue
+			}
+			cond = "a.provider = $4 AND a.recipient = $3"
+		default:
+			continue
+		}
+		from := `FROM ` + outMessageAttemptsRelation + ` AS a
+			INNER JOIN ` + outMessagesRelation + ` AS m ON m.id = a.out_message_id
+			WHERE m.app_id = $1 AND a.ok AND a.created_at > now() - $2 * interval '1 millisecond' AND ` + cond
+		var cnt int
+		if err := o.DBPool.QueryRow(o.ctx,
+			`SELECT count(*) `+from,
+			o.AppID, l.Window.Milliseconds(), key, prov,
+		).Scan(&cnt); err != nil {
+			logger.Logger.Errorf("Outbox message %d rate limit SELECT: %v", m.id, err)
+			continue
+		}
+		if cnt < l.Limit {
+			continue
+		}
+		// the limit is free when all deliveries above it leave the window
+		var until time.Time
+		if err := o.DBPool.QueryRow(o.ctx,
+			`SELECT a.created_at + $2 * interval '1 millisecond' `+from+`
+			ORDER BY a.created_at
+			OFFSET $5 LIMIT 1`,
+			o.AppID, l.Window.Milliseconds(), key, prov, cnt-l.Limit,
+		).Scan(&until); err != nil {
+			logger.Logger.Errorf("Outbox message %d rate limit window SELECT: %v", m.id, err)
+			until = time.Now().Add(l.Window)
+		}
+		if l.Provider == "" {
+			return fmt.Sprintf("%s: recipient rate limit %d per %v exceeded", prov, l.Limit, l.Window), until
+		}
+		return fmt.Sprintf("%s: rate limit %d per %v exceeded for %s", prov, l.Limit, l.Window, key), until
+	}
+	return "", time.Time{}
+}
+
+// mergeDigests replaces messages of every digest group with one digest message,
+// other messages of the group are closed as suppressed.
+// If the digest can not be built, messages are delivered one by one.
+func (o *Outbox) mergeDigests(list []*outMessage) []*outMessage {
+	if o.Policy == nil {
+		return list
+	}
+	groups := make(map[string][]*outMessage)
+	res := make([]*outMessage, 0, len(list))
+	for _, m := range list {
+		if r, ok := o.Policy.Digests[m.msg.MessageType]; !ok || r.Build == nil || m.digestKey == "" {
+			res = append(res, m)
+			continue
+		}
+		if _, ok := groups[m.digestKey]; !ok {
+			res = append(res, m) // the first message carries the digest
+		}
+		groups[m.digestKey] = append(groups[m.digestKey], m)
+	}
+
+	for _, group := range groups {
+		if len(group) == 1 {
+			continue
+		}
+		head := group[0]
+		msgs := make([]*NotifMessage, len(group))
+		for i, m := range group {
+			msgs[i] = &m.msg
+		}
+		digest, err := o.Policy.Digests[head.msg.MessageType].Build(o.ctx, msgs)
+		if err == nil {
+			err = o.storeDigest(head, digest)
+		}
+		if err != nil {
+			logger.Logger.Errorf("Outbox digest %s: %v", head.digestKey, err)
+			res = append(res, group[1:]...)
+			continue
+		}
+		head.msg = *digest
+		for _, m := range group[1:] {
+			o.close(m, OutStatusSuppressed, "", fmt.Sprintf("merged into digest %d", head.id))
+		}
+	}
+	return res
+}
+
+// storeDigest replaces the message with its digest, the digest key is cleared
+// so that the digest is not merged again on retries.
+func (o *Outbox) storeDigest(m *outMessage, digest *NotifMessage) error {
+	providers := make([]string, len(digest.Providers))
+	for i, p := range digest.Providers {
+		providers[i] = string(p)
+	}
+	msgB, err := json.Marshal(digest.Message)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %v", err)
+	}
+	if _, err := o.DBPool.Exec(o.ctx,
+		`UPDATE `+outMessagesRelation+`
+		SET providers = $2, message = $3, digest_key = NULL
+		WHERE id = $1`,
+		m.id, providers, msgB,
+	); err != nil {
+		return fmt.Errorf("UPDATE: %v", err)
+	}
+	m.digestKey = ""
+	return nil
+}
+
+// storeAttempt writes provider call result to the attempts table.
+func (o *Outbox) storeAttempt(m *outMessage, prov NotifProvider, sendErr error) {
+	var errText string
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	if _, err := o.DBPool.Exec(context.Background(),
+		`INSERT INTO `+outMessageAttemptsRelation+`
+		(out_message_id, attempt, provider, recipient, ok, error_text)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		m.id, m.attempts, prov, recipientAddr(&m.msg, prov), sendErr == nil, errText,
+	); err != nil {
+		logger.Logger.Errorf("Outbox message %d attempt INSERT: %v", m.id, err)
+	}
+}
+
+// close sets the final status of the message.
+func (o *Outbox) close(m *outMessage, status OutStatus, prov NotifProvider, lastErr string) {
+	if _, err := o.DBPool.Exec(context.Background(),
+		`UPDATE `+outMessagesRelation+`
+		SET status = $2, closed = TRUE, attempts = $3, provider = $4, last_error = $5
+		WHERE id = $1`,
+		m.id, status, m.attempts, prov, lastErr,
+	); err != nil {
+		logger.Logger.Errorf("Outbox message %d close UPDATE: %v", m.id, err)
+	}
+}
@@ -0,0 +1,151 @@
+package notif
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/dronm/gobizapp/errs"
+)
+
+// DefaultCountry is the country of phone numbers without a country code.
+var DefaultCountry = "RU"
+
+// telCountry is a country numbering plan.
+type telCountry struct {
+	code        string // country calling code
+	trunkPrefix string // national prefix replaced with the country code
+	minLen      int    // national number length without trunk prefix
+	maxLen      int
+}
+
+// telCountries by ISO 3166-1 alpha-2 code.
+var telCountries = map[string]telCountry{
+	"RU": {code: "7", trunkPrefix: "8", minLen: 10, maxLen: 10},
+	"KZ": {code: "7", trunkPrefix: "8", minLen: 10, maxLen: 10},
+	"BY": {code: "375", trunkPrefix: "80", minLen: 9, maxLen: 9},
+	"UA": {code: "380", trunkPrefix: "0", minLen: 9, maxLen: 9},
+	"UZ": {code: "998", minLen: 9, maxLen: 9},
+	"KG": {code: "996", trunkPrefix: "0", minLen: 9, maxLen: 9},
+	"AM": {code: "374", trunkPrefix: "0", minLen: 8, maxLen: 8},
+	"GE": {code: "995", trunkPrefix: "0", minLen: 9, maxLen: 9},
+	"AZ": {code: "994", trunkPrefix: "0", minLen: 9, maxLen: 9},
+	"TR": {code: "90", trunkPrefix: "0", minLen: 10, maxLen: 10},
+	"DE": {code: "49", trunkPrefix: "0", minLen: 6, maxLen: 13},
+	"GB": {code: "44", trunkPrefix: "0", minLen: 9, maxLen: 10},
+	"US": {code: "1", trunkPrefix: "1", minLen: 10, maxLen: 10},
+	"CA": {code: "1", trunkPrefix: "1", minLen: 10, maxLen: 10},
+}
+
+// RegisterTelCountry adds or replaces a country numbering plan
+// used by NormalizeTel.
+func RegisterTelCountry(country, code, trunkPrefix string, minLen, maxLen int) {
+	telCountries[strings.ToUpper(country)] = telCountry{code: code, trunkPrefix: trunkPrefix, minLen: minLen, maxLen: maxLen}
+}
+
+// NormalizeTel returns the phone number in E.164 format, e.g. +79161234567.
+// Spaces, dashes, dots and parentheses are removed, 00 is treated as +.
+// Numbers without + are national numbers of the country, DefaultCountry if empty:
+// for RU 8 (916) 123-45-67, 9161234567 and 79161234567 are all +79161234567.
+// An invalid number is returned as errs.InvalidTel public error.
+func NormalizeTel(tel, country string) (string, error) {
+	if strings.TrimSpace(tel) == "" {
+		return "", errors.New(ER_NO_TEL)
+	}
+	if country == "" {
+		country = DefaultCountry
+	}
+	var digits strings.Builder
+	intl := false
+	for i, c := range strings.TrimSpace(tel) {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c == '+' && i == 0:
+			intl = true
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+		}
+	}
+	num := digits.String()
+	if !intl && strings.HasPrefix(num, "00") {
+		num = num[2:]
+		intl = true
+	}
+
+	if !intl {
+		plan, ok := telCountries[strings.ToUpper(country)]
+		if !ok {
+			return "", errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+		}
+		switch {
+		case plan.fits(num):
+			num = plan.code + num
+		case plan.trunkPrefix != "" && strings.HasPrefix(num, plan.trunkPrefix) && plan.fits(num[len(plan.trunkPrefix):]):
+			num = plan.code + num[len(plan.trunkPrefix):]
+		case strings.HasPrefix(num, plan.code) && plan.fits(num[len(plan.code):]):
+		default:
+			return "", errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+		}
+	}
+
+	// E.164 allows up to 15 digits, numbers of known countries are checked by their plans
+	if len(num) < 8 || len(num) > 15 || num[0] == '0' {
+		return "", errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+	}
+	if plan, ok := telPlanByCode(num); ok && !plan.fits(num[len(plan.code):]) {
+		return "", errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+	}
+	return "+" + num, nil
+}
+
+func (p telCountry) fits(national string) bool {
+	return len(national) >= p.minLen && len(national) <= p.maxLen
+}
+
+// telPlanByCode returns the plan of the longest country code the number starts with.
+func telPlanByCode(num string) (telCountry, bool) {
+	var res telCountry
+	for _, plan := range telCountries {
+		if strings.HasPrefix(num, plan.code) && len(plan.code) > len(res.code) {
+			res = plan
+		}
+	}
+	return res, res.code != ""
+}
+
+// telOf returns the phone number of a typed provider message.
+func telOf(msg ProviderMessage) string {
+	switch m := msg.(type) {
+	case *SMSMessage:
+		return m.Tel
+	case *WAMessage:
+		return m.Tel
+	case *VBMessage:
+		return m.Tel
+	}
+	return ""
+}
+
+// normalizeTels normalizes phone numbers of typed provider messages in place.
+func normalizeTels(country string, msgs ...ProviderMessage) error {
+	for _, msg := range msgs {
+		var tel *string
+		switch m := msg.(type) {
+		case *SMSMessage:
+			tel = &m.Tel
+		case *WAMessage:
+			tel = &m.Tel
+		case *VBMessage:
+			tel = &m.Tel
+		default:
+			continue
+		}
+		norm, err := NormalizeTel(*tel, country)
+		if err != nil {
+			return err
+		}
+		*tel = norm
+	}
+	return nil
+}
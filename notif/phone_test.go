@@ -0,0 +1,48 @@
+package notif
+
+import "testing"
+
+func TestNormalizeTel(t *testing.T) {
+	tests := []struct {
+		name    string
+		tel     string
+		country string
+		want    string
+		wantErr bool
+	}{
+		{name: "national", tel: "9161234567", country: "RU", want: "+79161234567"},
+		{name: "default country", tel: "(916) 123-45-67", want: "+79161234567"},
+		{name: "trunk prefix", tel: "8 (916) 123-45-67", country: "RU", want: "+79161234567"},
+		{name: "two digit trunk prefix", tel: "8 029 123-45-67", country: "BY", want: "+375291234567"},
+		{name: "code without plus", tel: "7 916 123 45 67", country: "RU", want: "+79161234567"},
+		{name: "code without plus lower case country", tel: "375 29 123 45 67", country: "by", want: "+375291234567"},
+		{name: "international", tel: "+7.916.123.45.67", country: "US", want: "+79161234567"},
+		{name: "00 prefix", tel: "00 7 916 123 45 67", want: "+79161234567"},
+		{name: "00 prefix other country", tel: "0049 30 1234567", want: "+49301234567"},
+		{name: "shared code 7", tel: "+7 727 123 45 67", country: "KZ", want: "+77271234567"},
+		{name: "shared code 7 too short", tel: "+7 916 123 45", wantErr: true},
+		{name: "shared code 1", tel: "+1 212 555 0100", want: "+12125550100"},
+		{name: "shared code 1 trunk prefix", tel: "1 212 555 0100", country: "US", want: "+12125550100"},
+		{name: "shared code 1 national", tel: "416 555 0100", country: "CA", want: "+14165550100"},
+		{name: "shared code 1 too short", tel: "+1 212 555 01", wantErr: true},
+		{name: "longest code", tel: "+375 29 123 45 6", wantErr: true},
+		{name: "unknown code", tel: "+99 123 456 789", want: "+99123456789"},
+		{name: "leading zero", tel: "+0123456789", wantErr: true},
+		{name: "national too long", tel: "916 123 45 678", country: "RU", wantErr: true},
+		{name: "unknown country", tel: "9161234567", country: "XX", wantErr: true},
+		{name: "letter", tel: "916-123-45-67a", country: "RU", wantErr: true},
+		{name: "plus inside", tel: "8 916 +1234567", country: "RU", wantErr: true},
+		{name: "slash", tel: "916/123/45/67", country: "RU", wantErr: true},
+		{name: "empty", tel: "  ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeTel(tt.tel, tt.country)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: NormalizeTel(%q, %q) error = %v, wantErr %v", tt.name, tt.tel, tt.country, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: NormalizeTel(%q, %q) = %q, want %q", tt.name, tt.tel, tt.country, got, tt.want)
+		}
+	}
+}
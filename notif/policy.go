@@ -0,0 +1,146 @@
+package notif
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const defDedupWindow = time.Duration(1) * time.Hour
+
+// RateLimit allows Limit successful deliveries within Window.
+// With Provider the limit is counted per provider address (chat ID, phone, email),
+// without it the limit is counted per RecipientID over all providers.
+// A message over the limits of all its providers is postponed till a limit is free.
+type RateLimit struct {
+	Provider NotifProvider
+	Limit    int
+	Window   time.Duration
+}
+
+// DigestFunc merges messages of one type and recipient into one message.
+type DigestFunc func(ctx context.Context, msgs []*NotifMessage) (*NotifMessage, error)
+
+// DigestRule collects messages within Window after the first one
+// and sends them as one message built by Build.
+type DigestRule struct {
+	Window time.Duration
+	Build  DigestFunc
+}
+
+// Policy limits outbox deliveries. Duplicates and messages merged into digests
+// are kept in the out-message log with OutStatusSuppressed and the reason in last_error.
+// Messages sent with Notifier.SendContext are passed to the outbox if the policy
+// applies to them, see Notifier.Policed.
+type Policy struct {
+	RateLimits  []RateLimit
+	DedupWindow time.Duration         // messages with the same DedupKey are suppressed within the window, 1 hour if empty
+	Digests     map[string]DigestRule // by message type
+}
+
+func (p *Policy) dedupWindow() time.Duration {
+	if p.DedupWindow <= 0 {
+		return defDedupWindow
+	}
+	return p.DedupWindow
+}
+
+// Applies returns true if the message is deduplicated, rate limited or merged
+// into digests by the policy.
+func (p *Policy) Applies(m *NotifMessage) bool {
+	if p == nil {
+		return false
+	}
+	if m.DedupKey != "" || p.digestKey(m) != "" {
+		return true
+	}
+	for _, l := range p.RateLimits {
+		if l.Provider == "" && m.RecipientID != "" {
+			return true
+		}
+		for _, prov := range m.Providers {
+			if l.Provider == prov {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// digestKey returns a key grouping messages into one digest or empty string.
+func (p *Policy) digestKey(m *NotifMessage) string {
+	if p == nil {
+		return ""
+	}
+	if r, ok := p.Digests[m.MessageType]; !ok || r.Build == nil {
+		return ""
+	}
+	rcpt := m.RecipientID
+	if rcpt == "" && len(m.Providers) > 0 {
+		rcpt = string(m.Providers[0]) + ":" + recipientAddr(m, m.Providers[0])
+	}
+	return m.MessageType + "|" + rcpt
+}
+
+// recipientAddr returns the address of the provider part of the message.
+func recipientAddr(m *NotifMessage, prov NotifProvider) string {
+	var key string
+	switch prov {
+	case PROV_EMAIL:
+		key = "to_addr"
+	case PROV_TM:
+		key = "chat_id"
+	case PROV_SMS, PROV_WA, PROV_VB:
+		key = "tel"
+	default:
+		return ""
+	}
+	s, _ := m.Message[prov][key].(string)
+	return s
+}
+
+// TemplateDigest returns a digest builder rendering digestType templates.
+// Template fields are count and items, items is a list of provider
+// parameters of the merged messages, e.g. {{range .items}}{{.text}}{{end}}.
+// Addresses are taken from the first message, email subject from
+// the subject template value if set.
+func TemplateDigest(r *Renderer, digestType string) DigestFunc {
+	return func(ctx context.Context, msgs []*NotifMessage) (*NotifMessage, error) {
+		if len(msgs) == 0 {
+			return nil, fmt.Errorf("no messages for digest")
+		}
+		first := msgs[0]
+		d := &NotifMessage{
+			MessageType: first.MessageType,
+			RecipientID: first.RecipientID,
+			Message:     make(map[NotifProvider]map[string]any),
+		}
+		for _, prov := range first.Providers {
+			var items []map[string]any
+			for _, m := range msgs {
+				if params, ok := m.Message[prov]; ok {
+					items = append(items, params)
+				}
+			}
+			res, err := r.Render(ctx, digestType, prov, map[string]any{"count": len(items), "items": items})
+			if err != nil {
+				return nil, err
+			}
+			params := make(map[string]any, len(first.Message[prov]))
+			for k, v := range first.Message[prov] {
+				params[k] = v
+			}
+			if prov == PROV_EMAIL {
+				params["body"] = res.Body
+				if subj, ok := res.Values["subject"]; ok {
+					params["subject"] = subj
+				}
+			} else {
+				params["text"] = res.Body
+			}
+			d.Providers = append(d.Providers, prov)
+			d.Message[prov] = params
+		}
+		return d, nil
+	}
+}
@@ -0,0 +1,212 @@
+package notif
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AllMessageTypes is a message type key matching any type in preferences.
+const AllMessageTypes = "*"
+
+var (
+	ErrUnsubscribed       = errors.New("recipient unsubscribed from the message type")
+	ErrNoAllowedProviders = errors.New("recipient has no allowed providers for the message type")
+	ErrUnsubscribeToken   = errors.New("unsubscribe token is invalid")
+)
+
+// QuietHours is a do-not-disturb window in the recipient time zone,
+// From and To are in HH:MM format, the window may pass midnight.
+type QuietHours struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Preferences are recipient delivery settings.
+type Preferences struct {
+	RecipientID   string                     `json:"recipient_id"`
+	OptIns        map[string][]NotifProvider `json:"opt_ins"`        // allowed providers by message type or AllMessageTypes, all are allowed if no entry
+	ProviderOrder []NotifProvider            `json:"provider_order"` // preferred providers first
+	QuietHours    []QuietHours               `json:"quiet_hours"`
+	TimeZone      string                     `json:"time_zone"` // IANA name, UTC if empty
+	Unsubscribed  []string                   `json:"unsubscribed"`
+}
+
+// PreferenceStore returns recipient preferences, nil if the recipient has none.
+type PreferenceStore interface {
+	Preferences(ctx context.Context, recipientID string) (*Preferences, error)
+}
+
+// PrefsFilter applies recipient preferences to messages with RecipientID.
+// Mandatory message types, like password recovery, ignore opt-ins,
+// unsubscriptions and quiet hours, only the provider order is applied.
+type PrefsFilter struct {
+	Store     PreferenceStore
+	Mandatory []string
+}
+
+// Apply filters and reorders message providers. If the message falls in quiet
+// hours, the end of the window is returned, otherwise zero time.
+// ErrUnsubscribed and ErrNoAllowedProviders mean the message must not be sent.
+func (f *PrefsFilter) Apply(ctx context.Context, m *NotifMessage, now time.Time) (time.Time, error) {
+	if f == nil || f.Store == nil || m.RecipientID == "" {
+		return time.Time{}, nil
+	}
+	p, err := f.Store.Preferences(ctx, m.RecipientID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Preferences(%s): %v", m.RecipientID, err)
+	}
+	if p == nil {
+		return time.Time{}, nil
+	}
+	if slices.Contains(f.Mandatory, m.MessageType) {
+		m.Providers = p.orderProviders(m.Providers)
+		return time.Time{}, nil
+	}
+	return p.Apply(m, now)
+}
+
+// Apply filters and reorders message providers, see PrefsFilter.Apply.
+func (p *Preferences) Apply(m *NotifMessage, now time.Time) (time.Time, error) {
+	if p.IsUnsubscribed(m.MessageType) {
+		return time.Time{}, ErrUnsubscribed
+	}
+	allowed, ok := p.OptIns[m.MessageType]
+	if !ok {
+		allowed, ok = p.OptIns[AllMessageTypes]
+	}
+	providers := m.Providers
+	if ok {
+		providers = nil
+		for _, prov := range m.Providers {
+			if slices.Contains(allowed, prov) {
+				providers = append(providers, prov)
+			}
+		}
+	}
+	if len(providers) == 0 {
+		return time.Time{}, ErrNoAllowedProviders
+	}
+	m.Providers = p.orderProviders(providers)
+
+	return p.QuietUntil(now)
+}
+
+// IsUnsubscribed returns true if the recipient unsubscribed from the type or from all types.
+func (p *Preferences) IsUnsubscribed(messageType string) bool {
+	return slices.Contains(p.Unsubscribed, messageType) || slices.Contains(p.Unsubscribed, AllMessageTypes)
+}
+
+// orderProviders puts preferred providers first keeping the order of others.
+func (p *Preferences) orderProviders(providers []NotifProvider) []NotifProvider {
+	if len(p.ProviderOrder) == 0 {
+		return providers
+	}
+	res := make([]NotifProvider, 0, len(providers))
+	for _, prov := range p.ProviderOrder {
+		if slices.Contains(providers, prov) && !slices.Contains(res, prov) {
+			res = append(res, prov)
+		}
+	}
+	for _, prov := range providers {
+		if !slices.Contains(res, prov) {
+			res = append(res, prov)
+		}
+	}
+	return res
+}
+
+// QuietUntil returns the end of the quiet window containing now or zero time.
+func (p *Preferences) QuietUntil(now time.Time) (time.Time, error) {
+	if len(p.QuietHours) == 0 {
+		return time.Time{}, nil
+	}
+	loc := time.UTC
+	if p.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(p.TimeZone); err != nil {
+			return time.Time{}, fmt.Errorf("time zone %s: %v", p.TimeZone, err)
+		}
+	}
+	local := now.In(loc)
+	cur := local.Hour()*60 + local.Minute()
+	dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	var until time.Time
+	for _, q := range p.QuietHours {
+		from, err := parseDayMinute(q.From)
+		if err != nil {
+			return time.Time{}, err
+		}
+		to, err := parseDayMinute(q.To)
+		if err != nil {
+			return time.Time{}, err
+		}
+		var end time.Time
+		switch {
+		case from == to:
+			continue
+		case from < to && cur >= from && cur < to:
+			end = dayStart.Add(time.Duration(to) * time.Minute)
+		case from > to && cur >= from:
+			end = dayStart.AddDate(0, 0, 1).Add(time.Duration(to) * time.Minute)
+		case from > to && cur < to:
+			end = dayStart.Add(time.Duration(to) * time.Minute)
+		default:
+			continue
+		}
+		if end.After(until) {
+			until = end
+		}
+	}
+	return until, nil
+}
+
+func parseDayMinute(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("quiet hours time %q: expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// UnsubscribeToken returns a signed token to unsubscribe the recipient
+// from the message type, AllMessageTypes unsubscribes from all types.
+func UnsubscribeToken(secret, recipientID, messageType string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(recipientID + "\n" + messageType))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ParseUnsubscribeToken verifies the token and returns its recipient and message type.
+func ParseUnsubscribeToken(secret, token string) (recipientID, messageType string, err error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", ErrUnsubscribeToken
+	}
+	sigB, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", "", ErrUnsubscribeToken
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	if !hmac.Equal(sigB, mac.Sum(nil)) {
+		return "", "", ErrUnsubscribeToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", ErrUnsubscribeToken
+	}
+	recipientID, messageType, ok = strings.Cut(string(data), "\n")
+	if !ok || recipientID == "" {
+		return "", "", ErrUnsubscribeToken
+	}
+	return recipientID, messageType, nil
+}
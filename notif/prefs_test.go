@@ -0,0 +1,121 @@
+package notif
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestPreferencesQuietUntil(t *testing.T) {
+	day := func(d, h, m int) time.Time { return time.Date(2026, 1, d, h, m, 0, 0, time.UTC) }
+	night := []QuietHours{{From: "22:00", To: "07:00"}}
+
+	tests := []struct {
+		name    string
+		prefs   Preferences
+		now     time.Time
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "no windows", now: day(10, 23, 0)},
+		{name: "before midnight", prefs: Preferences{QuietHours: night}, now: day(10, 23, 30), want: day(11, 7, 0)},
+		{name: "after midnight", prefs: Preferences{QuietHours: night}, now: day(10, 3, 0), want: day(10, 7, 0)},
+		{name: "window start", prefs: Preferences{QuietHours: night}, now: day(10, 22, 0), want: day(11, 7, 0)},
+		{name: "window end", prefs: Preferences{QuietHours: night}, now: day(10, 7, 0)},
+		{name: "outside", prefs: Preferences{QuietHours: night}, now: day(10, 12, 0)},
+		{name: "same day", prefs: Preferences{QuietHours: []QuietHours{{From: "13:00", To: "14:00"}}}, now: day(10, 13, 30), want: day(10, 14, 0)},
+		{name: "from equals to", prefs: Preferences{QuietHours: []QuietHours{{From: "10:00", To: "10:00"}}}, now: day(10, 10, 0)},
+		{
+			name:  "overlapping windows",
+			prefs: Preferences{QuietHours: []QuietHours{{From: "22:00", To: "07:00"}, {From: "06:00", To: "09:00"}}},
+			now:   day(10, 6, 30),
+			want:  day(10, 9, 0),
+		},
+		{
+			name:  "time zone",
+			prefs: Preferences{QuietHours: night, TimeZone: "Europe/Moscow"},
+			now:   day(10, 20, 0), // 23:00 in Moscow
+			want:  day(11, 4, 0),  // 07:00 in Moscow
+		},
+		{name: "time zone outside", prefs: Preferences{QuietHours: night, TimeZone: "Europe/Moscow"}, now: day(10, 12, 0)},
+		{name: "bad time", prefs: Preferences{QuietHours: []QuietHours{{From: "25:00", To: "07:00"}}}, now: day(10, 12, 0), wantErr: true},
+		{name: "bad time zone", prefs: Preferences{QuietHours: night, TimeZone: "Nowhere/City"}, now: day(10, 12, 0), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tt.prefs.QuietUntil(tt.now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: QuietUntil() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: QuietUntil() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreferencesOrderProviders(t *testing.T) {
+	tests := []struct {
+		name      string
+		order     []NotifProvider
+		providers []NotifProvider
+		want      []NotifProvider
+	}{
+		{name: "no order", providers: []NotifProvider{PROV_EMAIL, PROV_TM}, want: []NotifProvider{PROV_EMAIL, PROV_TM}},
+		{
+			name:      "preferred first",
+			order:     []NotifProvider{PROV_TM, PROV_EMAIL},
+			providers: []NotifProvider{PROV_EMAIL, PROV_SMS, PROV_TM},
+			want:      []NotifProvider{PROV_TM, PROV_EMAIL, PROV_SMS},
+		},
+		{
+			name:      "unknown preferred",
+			order:     []NotifProvider{PROV_WA, PROV_SMS},
+			providers: []NotifProvider{PROV_EMAIL, PROV_SMS},
+			want:      []NotifProvider{PROV_SMS, PROV_EMAIL},
+		},
+		{
+			name:      "duplicate preferred",
+			order:     []NotifProvider{PROV_TM, PROV_TM},
+			providers: []NotifProvider{PROV_EMAIL, PROV_TM},
+			want:      []NotifProvider{PROV_TM, PROV_EMAIL},
+		},
+	}
+	for _, tt := range tests {
+		p := Preferences{ProviderOrder: tt.order}
+		if got := p.orderProviders(tt.providers); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: orderProviders() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseUnsubscribeToken(t *testing.T) {
+	const secret = "secret"
+	token := UnsubscribeToken(secret, "user1", "news")
+	payload, sig, _ := strings.Cut(token, ".")
+
+	rcpt, msgType, err := ParseUnsubscribeToken(secret, token)
+	if err != nil || rcpt != "user1" || msgType != "news" {
+		t.Fatalf("ParseUnsubscribeToken() = %q, %q, %v", rcpt, msgType, err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+	}{
+		{name: "wrong secret", secret: "other", token: token},
+		{name: "empty secret", secret: "", token: token},
+		{name: "no signature", secret: secret, token: payload},
+		{name: "tampered payload", secret: secret, token: UnsubscribeToken(secret, "user2", "news")[:len(payload)] + "." + sig},
+		{name: "tampered signature", secret: secret, token: payload + "." + sig[:len(sig)-2] + "AA"},
+		{name: "bad signature encoding", secret: secret, token: payload + ".!!"},
+		{name: "empty recipient", secret: secret, token: UnsubscribeToken(secret, "", "news")},
+	}
+	for _, tt := range tests {
+		if _, _, err := ParseUnsubscribeToken(tt.secret, tt.token); err != ErrUnsubscribeToken {
+			t.Errorf("%s: expected ErrUnsubscribeToken, got %v", tt.name, err)
+		}
+	}
+}
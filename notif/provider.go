@@ -0,0 +1,205 @@
+package notif
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Provider is a notification delivery driver.
+// Send delivers one provider part of the message, msg is NotifMessage.Message[prov].
+type Provider interface {
+	Send(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error
+}
+
+// ProviderFunc is an adapter to use functions as providers.
+type ProviderFunc func(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error
+
+func (f ProviderFunc) Send(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+	return f(ctx, messageType, prov, msg)
+}
+
+// RegisterProvider sets a driver for the provider.
+// Providers without a driver are sent through the gateway if it is configured.
+func (n *Notifier) RegisterProvider(prov NotifProvider, p Provider) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	if n.drivers == nil {
+		n.drivers = make(map[NotifProvider]Provider)
+	}
+	n.drivers[prov] = p
+}
+
+// driver returns a driver for the provider, the gateway or nil.
+func (n *Notifier) driver(prov NotifProvider) Provider {
+	n.mx.RLock()
+	p, ok := n.drivers[prov]
+	n.mx.RUnlock()
+	if ok {
+		return p
+	}
+	if g := n.gateway(); g != nil {
+		return g
+	}
+	return nil
+}
+
+func (n *Notifier) hasDriver(prov NotifProvider) bool {
+	n.mx.RLock()
+	defer n.mx.RUnlock()
+	_, ok := n.drivers[prov]
+	return ok
+}
+
+// gateway returns the gateway driver if the host is set.
+func (n *Notifier) gateway() *GatewayProvider {
+	if n.Host == "" {
+		return nil
+	}
+	return &GatewayProvider{Host: n.Host, AppName: n.AppName, Pwd: n.Pwd}
+}
+
+// Send sends the batch, see SendContext.
+func (n *Notifier) Send(batch []*NotifMessage) ([]*Response, error) {
+	return n.SendContext(context.Background(), batch)
+}
+
+// SendContext delivers messages and returns one response per message in batch order.
+// Messages without registered drivers for any of their providers are sent
+// to the gateway in one request as before, the gateway does its own fallback.
+// Other messages are sent by drivers, providers are tried in order of priority
+// and the next one is used if delivery fails. Invalid messages are not sent.
+// Messages with RecipientID are filtered by recipient preferences,
+// messages in quiet hours and messages subject to the delivery policy
+// (see Policed) are passed to Defer. A message with DedupKey is never sent
+// directly as deduplication is done by the outbox only.
+// A validation or delivery error is returned in Response.Error, a failed gateway
+// request is reported in the responses of its messages, so the responses of messages
+// already delivered by drivers are never lost.
+// Attachment sources of the batch are closed on return.
+func (n *Notifier) SendContext(ctx context.Context, batch []*NotifMessage) ([]*Response, error) {
+	// sources of attachments not sent due to errors are released
+	defer closeAttachments(batch)
+
+	responses := make([]*Response, len(batch))
+
+	var gwBatch []*NotifMessage
+	var gwInd []int
+	for i, m := range batch {
+		if err := m.Validate(); err != nil {
+			responses[i] = &Response{Error: err.Error()}
+			continue
+		}
+		if (n.Policed != nil && n.Policed(m)) || m.DedupKey != "" {
+			responses[i] = n.deferPoliced(ctx, m)
+			continue
+		}
+		if m.RecipientID != "" && n.Prefs != nil {
+			var resp *Response
+			if m, resp = n.applyPrefs(ctx, m); resp != nil {
+				responses[i] = resp
+				continue
+			}
+		}
+		if n.gateway() != nil && !n.anyDriver(m) {
+			gwBatch = append(gwBatch, m)
+			gwInd = append(gwInd, i)
+			continue
+		}
+		resp := &Response{}
+		if err := n.sendMessage(ctx, m); err != nil {
+			resp.Error = err.Error()
+		}
+		responses[i] = resp
+	}
+
+	if len(gwBatch) > 0 {
+		gwResp, err := n.gateway().SendBatch(ctx, gwBatch)
+		for j, i := range gwInd {
+			if err != nil {
+				responses[i] = &Response{Error: err.Error()}
+			} else if j < len(gwResp) {
+				responses[i] = gwResp[j]
+			} else {
+				responses[i] = &Response{}
+			}
+		}
+	}
+
+	return responses, nil
+}
+
+// applyPrefs returns the message with recipient providers or a response
+// if the message must not be sent now. Batch messages are not modified.
+func (n *Notifier) applyPrefs(ctx context.Context, m *NotifMessage) (*NotifMessage, *Response) {
+	msgCopy := *m
+	until, err := n.Prefs.Apply(ctx, &msgCopy, time.Now())
+	if err != nil {
+		return nil, &Response{Error: err.Error()}
+	}
+	if until.IsZero() {
+		return &msgCopy, nil
+	}
+	if n.Defer == nil {
+		return nil, &Response{Error: fmt.Sprintf("quiet hours until %s", until.Format(time.RFC3339))}
+	}
+	if err := n.Defer(ctx, m); err != nil {
+		return nil, &Response{Error: err.Error()}
+	}
+	return nil, &Response{}
+}
+
+// deferPoliced passes the message subject to the delivery policy to the outbox,
+// the outbox applies recipient preferences itself.
+func (n *Notifier) deferPoliced(ctx context.Context, m *NotifMessage) *Response {
+	if n.Defer == nil {
+		return &Response{Error: ER_POLICY_NO_OUTBOX}
+	}
+	if err := n.Defer(ctx, m); err != nil {
+		return &Response{Error: err.Error()}
+	}
+	return &Response{}
+}
+
+func (n *Notifier) anyDriver(m *NotifMessage) bool {
+	for _, prov := range m.Providers {
+		if n.hasDriver(prov) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendMessage tries message providers in order until one succeeds.
+func (n *Notifier) sendMessage(ctx context.Context, m *NotifMessage) error {
+	if len(m.Providers) == 0 {
+		return errors.New("no providers")
+	}
+	var errList []string
+	for _, prov := range m.Providers {
+		p := n.driver(prov)
+		if p == nil {
+			errList = append(errList, fmt.Sprintf("%s: no driver", prov))
+			continue
+		}
+		if err := p.Send(ctx, m.MessageType, prov, m.Message[prov]); err != nil {
+			errList = append(errList, fmt.Sprintf("%s: %v", prov, err))
+			continue
+		}
+		return nil
+	}
+	return errors.New(strings.Join(errList, ", "))
+}
+
+// decodeParams converts provider message parameters to a typed message.
+func decodeParams(msg map[string]any, v any) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
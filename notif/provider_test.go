@@ -0,0 +1,81 @@
+package notif
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendContextGatewayError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, "test", "pwd")
+	var sent int
+	n.RegisterProvider(PROV_TM, ProviderFunc(func(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+		sent++
+		return nil
+	}))
+
+	batch := []*NotifMessage{
+		(&TMMessage{ChatID: "123", Text: "hello"}).NewNotif("test"),
+		(&EmailMessage{ToAddr: "user@example.com", Subject: "Test", Body: "hello"}).NewNotif("test"),
+	}
+	resp, err := n.SendContext(context.Background(), batch)
+	if err != nil {
+		t.Fatalf("SendContext(): %v", err)
+	}
+	if len(resp) != len(batch) {
+		t.Fatalf("got %d responses, want %d", len(resp), len(batch))
+	}
+	if sent != 1 || resp[0].Error != "" {
+		t.Errorf("driver message is not reported as sent: %+v", resp[0])
+	}
+	if resp[1].Error == "" {
+		t.Error("gateway error is not reported for the gateway message")
+	}
+}
+
+func TestSendContextPolicy(t *testing.T) {
+	n := NewNotifier("", "", "")
+	var sent, deferred int
+	n.RegisterProvider(PROV_TM, ProviderFunc(func(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+		sent++
+		return nil
+	}))
+
+	dedup := (&TMMessage{ChatID: "123", Text: "hello"}).NewNotif("test")
+	dedup.DedupKey = "order:1"
+	resp, err := n.SendContext(context.Background(), []*NotifMessage{dedup})
+	if err != nil {
+		t.Fatalf("SendContext(): %v", err)
+	}
+	if resp[0].Error != ER_POLICY_NO_OUTBOX || sent != 0 {
+		t.Errorf("message with dedup key is sent without the outbox: %+v", resp[0])
+	}
+
+	policy := &Policy{RateLimits: []RateLimit{{Provider: PROV_TM, Limit: 1, Window: time.Minute}}}
+	n.Policed = policy.Applies
+	n.Defer = func(ctx context.Context, m *NotifMessage) error {
+		deferred++
+		return nil
+	}
+	batch := []*NotifMessage{
+		(&TMMessage{ChatID: "123", Text: "hello"}).NewNotif("test"),
+		(&EmailMessage{ToAddr: "user@example.com", Subject: "Test", Body: "hello"}).NewNotif("test"),
+	}
+	n.RegisterProvider(PROV_EMAIL, ProviderFunc(func(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+		sent++
+		return nil
+	}))
+	if _, err := n.SendContext(context.Background(), batch); err != nil {
+		t.Fatalf("SendContext(): %v", err)
+	}
+	if deferred != 1 || sent != 1 {
+		t.Errorf("rate limited message is not passed to the outbox: deferred %d, sent %d", deferred, sent)
+	}
+}
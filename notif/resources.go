@@ -4,6 +4,17 @@ const(
 	ER_TEMPLATE_NOT_FOUND = "не найден шаблон отправки сообщения"
 	ER_EMAIL_NO_TO_ADDR = "не задан адрес электронной почты получателя"
 	ER_NO_TEL = "не задан телефон"
+	ER_BAD_TEL = "неверный номер телефона"
+	ER_NO_CHAT_ID = "не задан идентификатор чата"
+	ER_BAD_CHAT_ID = "неверный идентификатор чата"
+	ER_BAD_EMAIL = "неверный адрес электронной почты"
+	ER_NO_TEXT = "не задан текст сообщения"
+	ER_TEXT_TOO_LONG = "текст сообщения длиннее %d символов"
+	ER_SUBJECT_TOO_LONG = "тема письма длиннее %d символов"
+	ER_ATTACHMENT_ALIAS = "количество имен вложений не совпадает с количеством вложений"
+	ER_NO_PROVIDER_MESSAGE = "нет сообщения для провайдера %s"
+	ER_POLICY_NO_OUTBOX = "сообщение с ограничениями доставки отправляется только через очередь сообщений"
+	ER_HEADER_NEWLINE = "перевод строки в заголовке письма %s"
 )
 
 
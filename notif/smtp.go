@@ -0,0 +1,340 @@
+package notif
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defSMTPTimeout = time.Duration(30) * time.Second
+
+// SMTPProvider sends email messages directly to an SMTP server.
+// With STARTTLS the connection is upgraded if the server supports it,
+// RequireTLS makes it mandatory. ImplicitTLS is for servers on port 465.
+// Authentication is used if Username is set.
+type SMTPProvider struct {
+	Host               string
+	Port               int
+	Username           string
+	Password           string
+	StartTLS           bool
+	RequireTLS         bool
+	ImplicitTLS        bool
+	InsecureSkipVerify bool
+	HelloName          string        // localhost if empty
+	FromAddr           string        // used if message has no from address
+	FromName           string        // used if message has no from name
+	Timeout            time.Duration // 30 seconds if empty
+}
+
+func (p *SMTPProvider) Send(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+	var em EmailMessage
+	if err := decodeParams(msg, &em); err != nil {
+		return fmt.Errorf("decodeParams(): %v", err)
+	}
+	em.Sources, _ = msg[attachmentSourcesParam].([]Attachment)
+	return p.SendEmail(ctx, &em)
+}
+
+// SendEmail sends the message with attachments.
+func (p *SMTPProvider) SendEmail(ctx context.Context, em *EmailMessage) error {
+	if em.ToAddr == "" {
+		return errors.New(ER_EMAIL_NO_TO_ADDR)
+	}
+	if em.FromAddr == "" {
+		em.FromAddr = p.FromAddr
+	}
+	if em.FromName == "" {
+		em.FromName = p.FromName
+	}
+	if em.FromAddr == "" {
+		return errors.New("from address is not defined")
+	}
+	if err := checkHeaderValues(em); err != nil {
+		return err
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defSMTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+	tlsConf := &tls.Config{ServerName: p.Host, InsecureSkipVerify: p.InsecureSkipVerify}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{}
+	if p.ImplicitTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConf}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("dial %s: %v", addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, p.Host)
+	if err != nil {
+		return fmt.Errorf("smtp.NewClient(): %v", err)
+	}
+	defer c.Close()
+
+	helloName := p.HelloName
+	if helloName == "" {
+		helloName = "localhost"
+	}
+	if err := c.Hello(helloName); err != nil {
+		return fmt.Errorf("HELO: %v", err)
+	}
+
+	if !p.ImplicitTLS && (p.StartTLS || p.RequireTLS) {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConf); err != nil {
+				return fmt.Errorf("STARTTLS: %v", err)
+			}
+		} else if p.RequireTLS {
+			return errors.New("server does not support STARTTLS")
+		}
+	}
+
+	if p.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", p.Username, p.Password, p.Host)); err != nil {
+			return fmt.Errorf("AUTH: %v", err)
+		}
+	}
+
+	sender := em.SenderAddr
+	if sender == "" {
+		sender = em.FromAddr
+	}
+	if err := c.Mail(sender); err != nil {
+		return fmt.Errorf("MAIL FROM: %v", err)
+	}
+	if err := c.Rcpt(em.ToAddr); err != nil {
+		return fmt.Errorf("RCPT TO: %v", err)
+	}
+
+	// attachments are opened before DATA so a missing source does not start the transaction
+	atts, err := openAttachments(ctx, em)
+	if err != nil {
+		return err
+	}
+	defer closeOpenedAttachments(atts)
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %v", err)
+	}
+	if err := writeEmail(w, em, atts); err != nil {
+		// the writer is not closed, it would end DATA and the server would
+		// deliver the truncated message, dropping the connection aborts the transaction
+		conn.Close()
+		return fmt.Errorf("writeEmail(): %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		conn.Close()
+		return fmt.Errorf("writeEmail(): %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("DATA close: %v", err)
+	}
+	return c.Quit()
+}
+
+// openedAttachment is an attachment with its reader opened.
+type openedAttachment struct {
+	name string
+	r    io.ReadCloser
+}
+
+// openAttachments opens file attachments and attachment sources of the message,
+// on error the already opened ones are closed.
+func openAttachments(ctx context.Context, em *EmailMessage) ([]openedAttachment, error) {
+	var atts []Attachment
+	for i, fileName := range em.Attachments {
+		var alias string
+		if i < len(em.AttachmentAlias) {
+			alias = em.AttachmentAlias[i]
+		}
+		atts = append(atts, FileAttachment(fileName, alias))
+	}
+	atts = append(atts, em.Sources...)
+
+	opened := make([]openedAttachment, 0, len(atts))
+	for _, a := range atts {
+		r, err := a.Open(ctx)
+		if err != nil {
+			closeOpenedAttachments(opened)
+			return nil, fmt.Errorf("attachment %s: %v", a.Name, err)
+		}
+		opened = append(opened, openedAttachment{name: a.Name, r: r})
+	}
+	return opened, nil
+}
+
+func closeOpenedAttachments(atts []openedAttachment) {
+	for _, a := range atts {
+		a.r.Close()
+	}
+}
+
+// writeEmail writes MIME message, body is sent as html if it looks like html.
+// Attachments are streamed from the opened readers.
+func writeEmail(w io.Writer, em *EmailMessage, atts []openedAttachment) error {
+	header := [][2]string{
+		{"From", (&mail.Address{Name: em.FromName, Address: em.FromAddr}).String()},
+		{"To", (&mail.Address{Name: em.ToName, Address: em.ToAddr}).String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", em.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+	}
+	if em.ReplyName != "" {
+		header = append(header, [2]string{"Reply-To", replyToAddress(em.ReplyName).String()})
+	}
+
+	bodyType := "text/plain; charset=utf-8"
+	if strings.HasPrefix(strings.TrimSpace(em.Body), "<") {
+		bodyType = "text/html; charset=utf-8"
+	}
+
+	if len(atts) == 0 {
+		header = append(header,
+			[2]string{"Content-Type", bodyType},
+			[2]string{"Content-Transfer-Encoding", "base64"},
+		)
+		writeHeader(w, header)
+		return writeBase64(w, strings.NewReader(em.Body))
+	}
+
+	mw := multipart.NewWriter(w)
+	header = append(header, [2]string{"Content-Type", "multipart/mixed; boundary=" + mw.Boundary()})
+	writeHeader(w, header)
+
+	bh := make(textproto.MIMEHeader)
+	bh.Set("Content-Type", bodyType)
+	bh.Set("Content-Transfer-Encoding", "base64")
+	pw, err := mw.CreatePart(bh)
+	if err != nil {
+		return err
+	}
+	if err := writeBase64(pw, strings.NewReader(em.Body)); err != nil {
+		return err
+	}
+
+	for _, a := range atts {
+		ah := make(textproto.MIMEHeader)
+		ah.Set("Content-Type", mimeType(a.name))
+		ah.Set("Content-Transfer-Encoding", "base64")
+		ah.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.name}))
+		pw, err := mw.CreatePart(ah)
+		if err != nil {
+			return err
+		}
+		if err := writeBase64(pw, a.r); err != nil {
+			return fmt.Errorf("attachment %s: %v", a.name, err)
+		}
+	}
+	return mw.Close()
+}
+
+// checkHeaderValues rejects CR and LF in values going to the message header
+// or SMTP commands, they would let a caller inject headers.
+func checkHeaderValues(em *EmailMessage) error {
+	for _, h := range [][2]string{
+		{"From", em.FromAddr},
+		{"From", em.FromName},
+		{"To", em.ToAddr},
+		{"To", em.ToName},
+		{"Reply-To", em.ReplyName},
+		{"Sender", em.SenderAddr},
+		{"Subject", em.Subject},
+	} {
+		if strings.ContainsAny(h[1], "\r\n") {
+			return fmt.Errorf(ER_HEADER_NEWLINE, h[0])
+		}
+	}
+	return nil
+}
+
+// replyToAddress returns reply address, the value is either an address
+// or a name with the address in angle brackets.
+func replyToAddress(v string) *mail.Address {
+	if a, err := mail.ParseAddress(v); err == nil {
+		return a
+	}
+	return &mail.Address{Address: v}
+}
+
+func writeHeader(w io.Writer, header [][2]string) {
+	for _, h := range header {
+		io.WriteString(w, h[0]+": "+h[1]+"\r\n")
+	}
+	io.WriteString(w, "\r\n")
+}
+
+// writeBase64 writes data in base64 with 76 character lines.
+func writeBase64(w io.Writer, r io.Reader) error {
+	lw := &lineWriter{w: w}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	if _, err := io.Copy(enc, r); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// lineWriter breaks output into lines of 76 characters.
+type lineWriter struct {
+	w   io.Writer
+	col int
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if l.col == 76 {
+			if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+				return n, err
+			}
+			l.col = 0
+		}
+		chunk := min(76-l.col, len(p))
+		if _, err := l.w.Write(p[:chunk]); err != nil {
+			return n, err
+		}
+		l.col += chunk
+		n += chunk
+		p = p[chunk:]
+	}
+	return n, nil
+}
+
+func mimeType(fileName string) string {
+	if t := mime.TypeByExtension(filepath.Ext(fileName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,88 @@
+package notif
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefTelegramAPIURL = "https://api.telegram.org"
+
+// TelegramProvider sends TMMessage with Telegram Bot API.
+type TelegramProvider struct {
+	Token     string
+	APIURL    string       // DefTelegramAPIURL if empty, can be set to a local stand-in
+	ParseMode string       // optional: HTML, MarkdownV2
+	Client    *http.Client // http.DefaultClient if nil
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (p *TelegramProvider) Send(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+	var tm TMMessage
+	if err := decodeParams(msg, &tm); err != nil {
+		return fmt.Errorf("decodeParams(): %v", err)
+	}
+	if tm.ChatID == "" {
+		return errors.New("chat ID is not defined")
+	}
+
+	body := map[string]string{"chat_id": tm.ChatID, "text": tm.Text}
+	if p.ParseMode != "" {
+		body["parse_mode"] = p.ParseMode
+	}
+	bodyB, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	apiURL := p.APIURL
+	if apiURL == "" {
+		apiURL = DefTelegramAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		strings.TrimSuffix(apiURL, "/")+"/bot"+p.Token+"/sendMessage",
+		bytes.NewReader(bodyB),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// url error contains the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respB, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var tr telegramResponse
+	if err := json.Unmarshal(respB, &tr); err != nil {
+		return fmt.Errorf("telegram response, http code %d: %v", resp.StatusCode, err)
+	}
+	if !tr.OK {
+		return fmt.Errorf("telegram error, http code %d: %s", resp.StatusCode, tr.Description)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+package notif
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defDateLayout     = "02.01.2006"
+	defDateTimeLayout = "02.01.2006 15:04"
+)
+
+// ErrTemplateData is returned if data do not match template fields.
+var ErrTemplateData = errors.New("template data do not match template fields")
+
+// Template is a message template of one provider and notification type.
+// Text is the message body, for email it is an html template.
+// Values are provider specific templates like email subject or sender,
+// they are always rendered as text.
+type Template struct {
+	Provider NotifProvider
+	Type     string
+	Text     string
+	Fields   []string          // data keys the template expects
+	Values   map[string]string // provider values by id
+}
+
+// TemplateLoader finds a template by notification type and provider.
+type TemplateLoader interface {
+	LoadTemplate(ctx context.Context, notifType string, prov NotifProvider) (*Template, error)
+}
+
+// TemplateLoaderFunc is an adapter to use functions as template loaders.
+type TemplateLoaderFunc func(ctx context.Context, notifType string, prov NotifProvider) (*Template, error)
+
+func (f TemplateLoaderFunc) LoadTemplate(ctx context.Context, notifType string, prov NotifProvider) (*Template, error) {
+	return f(ctx, notifType, prov)
+}
+
+// Recipient holds addresses for all providers,
+// only the address of the rendered provider is used.
+type Recipient struct {
+	Name   string
+	Email  string
+	Tel    string
+	ChatID string
+}
+
+// RenderedTemplate is a result of template execution.
+type RenderedTemplate struct {
+	Body   string            `json:"body"`
+	Values map[string]string `json:"values"`
+}
+
+// Validate checks that every declared field is in data and data has no other keys.
+func (t *Template) Validate(data map[string]any) error {
+	declared := make(map[string]struct{}, len(t.Fields))
+	var missing []string
+	for _, f := range t.Fields {
+		declared[f] = struct{}{}
+		if _, ok := data[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	var unknown []string
+	for k := range data {
+		if _, ok := declared[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(missing) == 0 && len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	var e []string
+	if len(missing) > 0 {
+		e = append(e, "missing fields: "+strings.Join(missing, ", "))
+	}
+	if len(unknown) > 0 {
+		e = append(e, "unknown fields: "+strings.Join(unknown, ", "))
+	}
+	return fmt.Errorf("%w: %s %s, %s", ErrTemplateData, t.Type, t.Provider, strings.Join(e, "; "))
+}
+
+// SampleData returns data for template preview, every field value is its name.
+func (t *Template) SampleData() map[string]any {
+	data := make(map[string]any, len(t.Fields))
+	for _, f := range t.Fields {
+		data[f] = "[" + f + "]"
+	}
+	return data
+}
+
+// Renderer builds provider messages from templates.
+// Templates have date, datetime and money functions:
+//
+//	{{date .created_at}}, {{date .created_at "2006-01-02"}}
+//	{{datetime .created_at}}
+//	{{money .total}}, {{money .total "руб."}}
+type Renderer struct {
+	Loader   TemplateLoader
+	Location *time.Location // dates are converted to this location if set
+	Country  string         // country of national recipient phone numbers, DefaultCountry if empty
+}
+
+func NewRenderer(loader TemplateLoader) *Renderer {
+	return &Renderer{Loader: loader}
+}
+
+// Render loads the template, validates data and executes the template.
+func (r *Renderer) Render(ctx context.Context, notifType string, prov NotifProvider, data map[string]any) (*RenderedTemplate, error) {
+	if r.Loader == nil {
+		return nil, fmt.Errorf("Render(): template loader is not defined")
+	}
+	t, err := r.Loader.LoadTemplate(ctx, notifType, prov)
+	if err != nil {
+		return nil, fmt.Errorf("LoadTemplate(%s, %s): %w", notifType, prov, err)
+	}
+	if t == nil {
+		return nil, errors.New(ER_TEMPLATE_NOT_FOUND)
+	}
+	if err := t.Validate(data); err != nil {
+		return nil, err
+	}
+	return r.Execute(t, data)
+}
+
+// Execute executes the template without validation.
+func (r *Renderer) Execute(t *Template, data map[string]any) (*RenderedTemplate, error) {
+	res := &RenderedTemplate{Values: make(map[string]string, len(t.Values))}
+
+	var err error
+	if t.Provider == PROV_EMAIL {
+		res.Body, err = r.executeHTML(t.Type, t.Text, data)
+	} else {
+		res.Body, err = r.executeText(t.Type, t.Text, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for id, v := range t.Values {
+		if res.Values[id], err = r.executeText(t.Type+"."+id, v, data); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (r *Renderer) executeText(name, text string, data map[string]any) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(r.funcs()).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("template %s Parse(): %v", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template %s Execute(): %v", name, err)
+	}
+	return b.String(), nil
+}
+
+func (r *Renderer) executeHTML(name, text string, data map[string]any) (string, error) {
+	tmpl, err := htmlTemplate.New(name).Option("missingkey=error").Funcs(r.funcs()).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("template %s Parse(): %v", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template %s Execute(): %v", name, err)
+	}
+	return b.String(), nil
+}
+
+func (r *Renderer) funcs() map[string]any {
+	return map[string]any{
+		"date": func(v any, layout ...string) (string, error) {
+			return r.formatTime(v, defDateLayout, layout)
+		},
+		"datetime": func(v any, layout ...string) (string, error) {
+			return r.formatTime(v, defDateTimeLayout, layout)
+		},
+		"money": FormatMoney,
+	}
+}
+
+func (r *Renderer) formatTime(v any, defLayout string, layout []string) (string, error) {
+	t, err := toTime(v)
+	if err != nil || t.IsZero() {
+		return "", err
+	}
+	if r.Location != nil {
+		t = t.In(r.Location)
+	}
+	if len(layout) > 0 {
+		defLayout = layout[0]
+	}
+	return t.Format(defLayout), nil
+}
+
+func toTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return t, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, nil
+		}
+		return *t, nil
+	case string:
+		if t == "" {
+			return time.Time{}, nil
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", time.DateOnly} {
+			if tm, err := time.Parse(layout, t); err == nil {
+				return tm, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("date: can not parse %q", t)
+	}
+	return time.Time{}, fmt.Errorf("date: unsupported type %T", v)
+}
+
+// FormatMoney formats the amount with two decimals, space separated thousands
+// and decimal comma: 1 234,50. Optional currency is added after the amount.
+func FormatMoney(v any, currency ...string) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	s := strconv.FormatFloat(math.Abs(f), 'f', 2, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	if f < 0 && s != "0.00" {
+		b.WriteString("-")
+	}
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(" ")
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString(",")
+	b.WriteString(frac)
+	if len(currency) > 0 && currency[0] != "" {
+		b.WriteString(" ")
+		b.WriteString(currency[0])
+	}
+	return b.String(), nil
+}
+
+func toFloat(v any) (float64, error) {
+	switch n := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	case string:
+		if n == "" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(strings.ReplaceAll(n, ",", "."), 64)
+	}
+	return 0, fmt.Errorf("money: unsupported type %T", v)
+}
+
+// Email renders an email template, subject, from_addr, from_name,
+// reply_name and sender_addr are taken from template values.
+func (r *Renderer) Email(ctx context.Context, notifType string, rcpt Recipient, data map[string]any) (*EmailMessage, error) {
+	if rcpt.Email == "" {
+		return nil, errors.New(ER_EMAIL_NO_TO_ADDR)
+	}
+	res, err := r.Render(ctx, notifType, PROV_EMAIL, data)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailMessage{
+		FromAddr:   res.Values["from_addr"],
+		FromName:   res.Values["from_name"],
+		ReplyName:  res.Values["reply_name"],
+		SenderAddr: res.Values["sender_addr"],
+		Subject:    res.Values["subject"],
+		ToAddr:     rcpt.Email,
+		ToName:     rcpt.Name,
+		Body:       res.Body,
+	}, nil
+}
+
+// SMS renders an SMS template.
+func (r *Renderer) SMS(ctx context.Context, notifType string, rcpt Recipient, data map[string]any) (*SMSMessage, error) {
+	tel, err := NormalizeTel(rcpt.Tel, r.Country)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.Render(ctx, notifType, PROV_SMS, data)
+	if err != nil {
+		return nil, err
+	}
+	return &SMSMessage{Tel: tel, Text: res.Body}, nil
+}
+
+// WA renders a WhatsApp template.
+func (r *Renderer) WA(ctx context.Context, notifType string, rcpt Recipient, data map[string]any) (*WAMessage, error) {
+	tel, err := NormalizeTel(rcpt.Tel, r.Country)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.Render(ctx, notifType, PROV_WA, data)
+	if err != nil {
+		return nil, err
+	}
+	return &WAMessage{Tel: tel, Text: res.Body}, nil
+}
+
+// VB renders a Viber template.
+func (r *Renderer) VB(ctx context.Context, notifType string, rcpt Recipient, data map[string]any) (*VBMessage, error) {
+	tel, err := NormalizeTel(rcpt.Tel, r.Country)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.Render(ctx, notifType, PROV_VB, data)
+	if err != nil {
+		return nil, err
+	}
+	return &VBMessage{Tel: tel, Text: res.Body}, nil
+}
+
+// TM renders a Telegram template.
+func (r *Renderer) TM(ctx context.Context, notifType string, rcpt Recipient, data map[string]any) (*TMMessage, error) {
+	if rcpt.ChatID == "" {
+		return nil, errors.New(ER_NO_TEL)
+	}
+	res, err := r.Render(ctx, notifType, PROV_TM, data)
+	if err != nil {
+		return nil, err
+	}
+	return &TMMessage{ChatID: rcpt.ChatID, Text: res.Body}, nil
+}
+
+// NotifMessage renders templates of all providers and returns one message
+// with providers in the given order of priority.
+func (r *Renderer) NotifMessage(ctx context.Context, notifType string, rcpt Recipient, data map[string]any, providers ...NotifProvider) (*NotifMessage, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("NotifMessage(): no providers")
+	}
+	m := NewNotifMessage(notifType, providers...)
+	for _, prov := range providers {
+		var msg interface{ SetParams(*NotifMessage) }
+		var err error
+		switch prov {
+		case PROV_EMAIL:
+			msg, err = r.Email(ctx, notifType, rcpt, data)
+		case PROV_SMS:
+			msg, err = r.SMS(ctx, notifType, rcpt, data)
+		case PROV_WA:
+			msg, err = r.WA(ctx, notifType, rcpt, data)
+		case PROV_TM:
+			msg, err = r.TM(ctx, notifType, rcpt, data)
+		case PROV_VB:
+			msg, err = r.VB(ctx, notifType, rcpt, data)
+		default:
+			err = fmt.Errorf("provider %s is not supported by templates", prov)
+		}
+		if err != nil {
+			return nil, err
+		}
+		msg.SetParams(m)
+	}
+	return m, nil
+}
@@ -0,0 +1,188 @@
+package notif
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dronm/gobizapp/errs"
+)
+
+// Text length limits in characters.
+const (
+	SMSMaxLen          = 670 // 10 UCS-2 segments
+	TMMaxLen           = 4096
+	WAMaxLen           = 4096
+	VBMaxLen           = 7000
+	EmailSubjectMaxLen = 255
+)
+
+// ProviderMessage is a typed message of one provider.
+type ProviderMessage interface {
+	Provider() NotifProvider
+	SetParams(notifMsg *NotifMessage)
+	Validate() error
+}
+
+func (msg *EmailMessage) Provider() NotifProvider { return PROV_EMAIL }
+func (msg *SMSMessage) Provider() NotifProvider   { return PROV_SMS }
+func (msg *TMMessage) Provider() NotifProvider    { return PROV_TM }
+func (msg *WAMessage) Provider() NotifProvider    { return PROV_WA }
+func (msg *VBMessage) Provider() NotifProvider    { return PROV_VB }
+
+func (msg *EmailMessage) Validate() error {
+	if msg.ToAddr == "" {
+		return errors.New(ER_EMAIL_NO_TO_ADDR)
+	}
+	if err := validateEmail(msg.ToAddr); err != nil {
+		return err
+	}
+	if msg.FromAddr != "" {
+		if err := validateEmail(msg.FromAddr); err != nil {
+			return err
+		}
+	}
+	if utf8.RuneCountInString(msg.Subject) > EmailSubjectMaxLen {
+		return fmt.Errorf(ER_SUBJECT_TOO_LONG, EmailSubjectMaxLen)
+	}
+	if len(msg.AttachmentAlias) > 0 && len(msg.AttachmentAlias) != len(msg.Attachments) {
+		return errors.New(ER_ATTACHMENT_ALIAS)
+	}
+	return nil
+}
+
+func (msg *SMSMessage) Validate() error {
+	if err := ValidateTel(msg.Tel); err != nil {
+		return err
+	}
+	return validateText(msg.Text, SMSMaxLen)
+}
+
+func (msg *TMMessage) Validate() error {
+	if err := ValidateChatID(msg.ChatID); err != nil {
+		return err
+	}
+	return validateText(msg.Text, TMMaxLen)
+}
+
+func (msg *WAMessage) Validate() error {
+	if err := ValidateTel(msg.Tel); err != nil {
+		return err
+	}
+	return validateText(msg.Text, WAMaxLen)
+}
+
+func (msg *VBMessage) Validate() error {
+	if err := ValidateTel(msg.Tel); err != nil {
+		return err
+	}
+	return validateText(msg.Text, VBMaxLen)
+}
+
+// ValidateTel checks an international phone number: optional plus
+// and 8 to 15 digits. Use NormalizeTel to convert national numbers.
+func ValidateTel(tel string) error {
+	if tel == "" {
+		return errors.New(ER_NO_TEL)
+	}
+	digits := strings.TrimPrefix(tel, "+")
+	if len(digits) < 8 || len(digits) > 15 {
+		return errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return errs.NewPublicErrorWithTemplate(errs.InvalidTel, tel)
+		}
+	}
+	return nil
+}
+
+// ValidateChatID checks a Telegram chat: numeric ID or @channel name.
+func ValidateChatID(chatID string) error {
+	if chatID == "" {
+		return errors.New(ER_NO_CHAT_ID)
+	}
+	if name, ok := strings.CutPrefix(chatID, "@"); ok {
+		if len(name) < 5 {
+			return errors.New(ER_BAD_CHAT_ID)
+		}
+		return nil
+	}
+	if _, err := strconv.ParseInt(chatID, 10, 64); err != nil {
+		return errors.New(ER_BAD_CHAT_ID)
+	}
+	return nil
+}
+
+func validateEmail(addr string) error {
+	a, err := mail.ParseAddress(addr)
+	if err != nil || a.Address != addr {
+		return fmt.Errorf("%s: %s", ER_BAD_EMAIL, addr)
+	}
+	return nil
+}
+
+func validateText(text string, maxLen int) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New(ER_NO_TEXT)
+	}
+	if utf8.RuneCountInString(text) > maxLen {
+		return fmt.Errorf(ER_TEXT_TOO_LONG, maxLen)
+	}
+	return nil
+}
+
+// providerMessage returns an empty typed message for the provider
+// or nil if the provider has no typed message.
+func providerMessage(prov NotifProvider) ProviderMessage {
+	switch prov {
+	case PROV_EMAIL:
+		return &EmailMessage{}
+	case PROV_SMS:
+		return &SMSMessage{}
+	case PROV_TM:
+		return &TMMessage{}
+	case PROV_WA:
+		return &WAMessage{}
+	case PROV_VB:
+		return &VBMessage{}
+	}
+	return nil
+}
+
+// Validate checks every provider part of the message.
+// Parts of unknown providers are not checked.
+// Phone numbers of SMS, WhatsApp and Viber parts are normalized to E.164
+// with DefaultCountry in place, so messages built without Builder
+// may keep national numbers like 8 (916) 123-45-67.
+func (nm *NotifMessage) Validate() error {
+	if len(nm.Providers) == 0 {
+		return errors.New("no providers")
+	}
+	for _, prov := range nm.Providers {
+		params, ok := nm.Message[prov]
+		if !ok {
+			return fmt.Errorf(ER_NO_PROVIDER_MESSAGE, prov)
+		}
+		msg := providerMessage(prov)
+		if msg == nil {
+			continue
+		}
+		if err := decodeParams(params, msg); err != nil {
+			return fmt.Errorf("%s: %v", prov, err)
+		}
+		if telOf(msg) != "" {
+			if err := normalizeTels(DefaultCountry, msg); err != nil {
+				return fmt.Errorf("%s: %w", prov, err)
+			}
+			params["tel"] = telOf(msg)
+		}
+		if err := msg.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", prov, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package notif
+
+import "testing"
+
+func TestNotifMessageValidateNormalizesTel(t *testing.T) {
+	m := &NotifMessage{
+		MessageType: "test",
+		Providers:   []NotifProvider{PROV_SMS},
+		Message: map[NotifProvider]map[string]any{
+			PROV_SMS: {"tel": "8 (916) 123-45-67", "text": "hello"},
+		},
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Validate(): %v", err)
+	}
+	if tel := m.Message[PROV_SMS]["tel"]; tel != "+79161234567" {
+		t.Errorf("tel = %v, want +79161234567", tel)
+	}
+
+	m.Message[PROV_SMS]["tel"] = "12-34"
+	if err := m.Validate(); err == nil {
+		t.Error("invalid tel is accepted")
+	}
+}
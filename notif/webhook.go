@@ -0,0 +1,125 @@
+package notif
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookSignatureHeader = "X-Notif-Signature"
+	WebhookTimestampHeader = "X-Notif-Timestamp" // unix seconds, signed together with the body
+
+	DefSignatureMaxAge = time.Duration(5) * time.Minute
+)
+
+// Sign returns hex HMAC-SHA256 signature of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks hex HMAC-SHA256 signature of the body.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// SignTimestamp returns hex HMAC-SHA256 signature of the timestamp
+// and the body joined with a dot.
+func SignTimestamp(secret string, timestamp int64, body []byte) string {
+	return Sign(secret, timestampedBody(strconv.FormatInt(timestamp, 10), body))
+}
+
+// VerifyTimestampSignature checks the signature made with SignTimestamp.
+// Requests with a timestamp differing from now by more than maxAge
+// are rejected, so a captured request can not be replayed later.
+func VerifyTimestampSignature(secret string, body []byte, signature, timestamp string, maxAge time.Duration) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if maxAge <= 0 {
+		maxAge = DefSignatureMaxAge
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > maxAge || age < -maxAge {
+		return false
+	}
+	return VerifySignature(secret, timestampedBody(timestamp, body), signature)
+}
+
+func timestampedBody(timestamp string, body []byte) []byte {
+	b := make([]byte, 0, len(timestamp)+1+len(body))
+	b = append(b, timestamp...)
+	b = append(b, '.')
+	return append(b, body...)
+}
+
+// WebhookProvider posts messages as JSON to an arbitrary http endpoint.
+// Any 2xx status is a success. If Secret is set, the body is signed with
+// HMAC-SHA256 together with the timestamp, see SignTimestamp, hex signature
+// is sent in WebhookSignatureHeader and the timestamp in WebhookTimestampHeader.
+type WebhookProvider struct {
+	URL     string
+	Headers map[string]string
+	Secret  string
+	Client  *http.Client // http.DefaultClient if nil
+}
+
+// WebhookPayload is a body of the webhook request.
+type WebhookPayload struct {
+	MessageType string         `json:"message_type"`
+	Provider    NotifProvider  `json:"provider"`
+	Message     map[string]any `json:"message"`
+}
+
+func (p *WebhookProvider) Send(ctx context.Context, messageType string, prov NotifProvider, msg map[string]any) error {
+	body, err := json.Marshal(WebhookPayload{MessageType: messageType, Provider: prov, Message: msg})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.Secret != "" {
+		ts := time.Now().Unix()
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(WebhookSignatureHeader, SignTimestamp(p.Secret, ts, body))
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respB, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook http code %d: %s", resp.StatusCode, respB)
+	}
+	return nil
+}
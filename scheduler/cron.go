@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with minute precision.
+// Standard five fields are supported: minute, hour, day of month, month, day of week,
+// each field can be *, a value, a range (1-5), a list (1,3,5) and a step (*/15, 1-30/5).
+// Descriptors @yearly, @monthly, @weekly, @daily, @hourly are supported as well.
+// If both day of month and day of week are restricted, a day matching any of them is used.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var (
+	fieldMinute = cronField{0, 59}
+	fieldHour   = cronField{0, 23}
+	fieldDom    = cronField{1, 31}
+	fieldMonth  = cronField{1, 12}
+	fieldDow    = cronField{0, 7} // 0 and 7 are Sunday
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses cron expression.
+func ParseCron(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron expression %q: expected 5 fields, got %d", spec, len(parts))
+	}
+
+	sch := &Schedule{
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}
+	var err error
+	if sch.minute, err = parseCronField(parts[0], fieldMinute); err != nil {
+		return nil, fmt.Errorf("cron expression %q minute: %v", spec, err)
+	}
+	if sch.hour, err = parseCronField(parts[1], fieldHour); err != nil {
+		return nil, fmt.Errorf("cron expression %q hour: %v", spec, err)
+	}
+	if sch.dom, err = parseCronField(parts[2], fieldDom); err != nil {
+		return nil, fmt.Errorf("cron expression %q day of month: %v", spec, err)
+	}
+	if sch.month, err = parseCronField(parts[3], fieldMonth); err != nil {
+		return nil, fmt.Errorf("cron expression %q month: %v", spec, err)
+	}
+	if sch.dow, err = parseCronField(parts[4], fieldDow); err != nil {
+		return nil, fmt.Errorf("cron expression %q day of week: %v", spec, err)
+	}
+	if sch.dow&(1<<7) != 0 {
+		sch.dow = sch.dow&^(1<<7) | 1
+	}
+
+	return sch, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for item := range strings.SplitSeq(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		var from, to int
+		switch {
+		case rng == "*":
+			from, to = f.min, f.max
+		case strings.Contains(rng, "-"):
+			fromStr, toStr, _ := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(fromStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", fromStr)
+			}
+			if to, err = strconv.Atoi(toStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", toStr)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			from, to = v, v
+			if hasStep {
+				to = f.max
+			}
+		}
+		if from < f.min || to > f.max || from > to {
+			return 0, fmt.Errorf("value %q out of range %d-%d", item, f.min, f.max)
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t matching the schedule, in t location.
+// Zero time is returned if nothing found within five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domOk := s.dom&(1<<uint(t.Day())) != 0
+	dowOk := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domOk && dowOk
+	}
+	return domOk || dowOk
+}
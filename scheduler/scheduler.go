@@ -0,0 +1,518 @@
+// Package scheduler runs service methods periodically by cron expressions
+// or once at a given time.
+// Only one application instance executes jobs, the leader is elected
+// with a postgres advisory lock. Jobs are executed with api.CallMethod
+// and system session, the same way as local events of the event server.
+// Delayed calls queued by users with DelayAs run with the user session.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/dronm/session"
+
+	"github.com/dronm/gobizapp/api"
+	"github.com/dronm/gobizapp/database"
+	"github.com/dronm/gobizapp/logger"
+	gbSession "github.com/dronm/gobizapp/session"
+)
+
+const (
+	DefLockKey = 7240311 // advisory lock key for leader election
+
+	defPollInterval   = time.Duration(1) * time.Second
+	defLeaderInterval = time.Duration(10) * time.Second
+	defJobTimeout     = time.Duration(10) * time.Minute
+	delayedBatchSize  = 100
+
+	scheduledEventsRelation = "scheduled_events"
+	historyRelation         = "scheduler_history"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusDone      Status = "done"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+var (
+	ErrJobExists        = errors.New("job already exists")
+	ErrMethodNotAllowed = errors.New("method is not allowed")
+)
+
+// Job is a periodic service call.
+type Job struct {
+	ID      string
+	Spec    string // cron expression
+	Service string
+	Method  string
+	Params  []string // method parameters in order, without context
+
+	schedule *Schedule
+	next     time.Time
+	running  atomic.Bool
+}
+
+// JobInfo is a job state for reporting.
+type JobInfo struct {
+	ID      string    `json:"id"`
+	Spec    string    `json:"spec"`
+	Service string    `json:"service"`
+	Method  string    `json:"method"`
+	Params  []string  `json:"params"`
+	NextRun time.Time `json:"next_run"`
+	Running bool      `json:"running"`
+}
+
+type Scheduler struct {
+	DBPool         *pgxpool.Pool
+	LockKey        int64
+	PollInterval   time.Duration // how often jobs and delayed events are checked
+	LeaderInterval time.Duration // how often a follower tries to become the leader
+	JobTimeout     time.Duration // max duration of one call
+	SystemSession  session.Session
+
+	// IsMethodAllowed checks user permission for delayed calls, DelayAs
+	// is refused if it is not set. Method is in ServiceMethod format as in ws.
+	IsMethodAllowed func(userSess session.Session, method string) error
+	// SessionLoader restores the session of a delayed call queued with DelayAs,
+	// e.g. SessionManager.SessionStart.
+	SessionLoader func(sessionID string) (session.Session, error)
+
+	jobsMx sync.Mutex
+	jobs   map[string]*Job
+
+	isLeader   atomic.Bool
+	ctx        context.Context
+	cancel     context.CancelFunc
+	cancelDone chan struct{}
+	wg         sync.WaitGroup // running calls
+}
+
+func NewScheduler(dbPool *pgxpool.Pool) *Scheduler {
+	return &Scheduler{
+		DBPool:         dbPool,
+		LockKey:        DefLockKey,
+		PollInterval:   defPollInterval,
+		LeaderInterval: defLeaderInterval,
+		JobTimeout:     defJobTimeout,
+		SystemSession:  gbSession.NewSystemSession(),
+		jobs:           make(map[string]*Job),
+	}
+}
+
+// AddCron registers a periodic job. Spec is a cron expression, see ParseCron.
+func (s *Scheduler) AddCron(id, spec, service, method string, params ...string) error {
+	sch, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	s.jobsMx.Lock()
+	defer s.jobsMx.Unlock()
+
+	if _, ok := s.jobs[id]; ok {
+		return fmt.Errorf("AddCron(%s): %w", id, ErrJobExists)
+	}
+	s.jobs[id] = &Job{
+		ID:       id,
+		Spec:     spec,
+		Service:  service,
+		Method:   method,
+		Params:   params,
+		schedule: sch,
+		next:     sch.Next(time.Now()),
+	}
+	return nil
+}
+
+// RemoveCron unregisters a periodic job.
+func (s *Scheduler) RemoveCron(id string) {
+	s.jobsMx.Lock()
+	delete(s.jobs, id)
+	s.jobsMx.Unlock()
+}
+
+// Jobs returns registered periodic jobs ordered by next run time.
+func (s *Scheduler) Jobs() []JobInfo {
+	s.jobsMx.Lock()
+	list := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		list = append(list, JobInfo{
+			ID:      j.ID,
+			Spec:    j.Spec,
+			Service: j.Service,
+			Method:  j.Method,
+			Params:  j.Params,
+			NextRun: j.next,
+			Running: j.running.Load(),
+		})
+	}
+	s.jobsMx.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].NextRun.Before(list[j].NextRun) })
+	return list
+}
+
+// IsLeader returns true if this instance executes jobs.
+func (s *Scheduler) IsLeader() bool {
+	return s.isLeader.Load()
+}
+
+// Delay stores a one-off call to be executed at runAt time with the system session.
+// It is meant for internal calls, use DelayAs for calls requested by users.
+// It returns the ID of the delayed event.
+func (s *Scheduler) Delay(ctx context.Context, runAt time.Time, service, method string, params ...string) (int64, error) {
+	return s.delay(ctx, "", runAt, service, method, params)
+}
+
+// DelayAs stores a one-off call on behalf of the user. The user permission for
+// the method is checked now and before the call, the call runs with the user session.
+func (s *Scheduler) DelayAs(ctx context.Context, userSess session.Session, runAt time.Time, service, method string, params ...string) (int64, error) {
+	if userSess == nil {
+		return 0, fmt.Errorf("DelayAs(): %w: no session", ErrMethodNotAllowed)
+	}
+	if userSess.SessionID() == gbSession.SystemSessionID {
+		return s.Delay(ctx, runAt, service, method, params...)
+	}
+	if err := s.checkMethod(userSess, service, method); err != nil {
+		return 0, fmt.Errorf("DelayAs(): %w", err)
+	}
+	return s.delay(ctx, userSess.SessionID(), runAt, service, method, params)
+}
+
+func (s *Scheduler) checkMethod(userSess session.Session, service, method string) error {
+	if s.IsMethodAllowed == nil {
+		return fmt.Errorf("%w: IsMethodAllowed is not set", ErrMethodNotAllowed)
+	}
+	if err := s.IsMethodAllowed(userSess, service+method); err != nil {
+		return fmt.Errorf("%w: %s.%s: %v", ErrMethodNotAllowed, service, method, err)
+	}
+	return nil
+}
+
+func (s *Scheduler) delay(ctx context.Context, sessionID string, runAt time.Time, service, method string, params []string) (int64, error) {
+	if params == nil {
+		params = []string{}
+	}
+	var id int64
+	if err := s.DBPool.QueryRow(ctx,
+		`INSERT INTO `+scheduledEventsRelation+`
+		(run_at, service, method, params, status, session_id)
+		VALUES ($1, $2, $3, $4, $5, nullif($6, ''))
+		RETURNING id`,
+		runAt, service, method, params, StatusPending, sessionID,
+	).Scan(&id); err != nil {
+		return 0, fmt.Errorf("Delay INSERT: %v", err)
+	}
+	return id, nil
+}
+
+// Cancel cancels a pending delayed event.
+func (s *Scheduler) Cancel(ctx context.Context, id int64) error {
+	cmd, err := s.DBPool.Exec(ctx,
+		`UPDATE `+scheduledEventsRelation+`
+		SET status = $2
+		WHERE id = $1 AND status = $3`,
+		id, StatusCancelled, StatusPending,
+	)
+	if err != nil {
+		return fmt.Errorf("Cancel UPDATE: %v", err)
+	}
+	if cmd.RowsAffected() == 0 {
+		return fmt.Errorf("pending delayed event not found by ID: %d", id)
+	}
+	return nil
+}
+
+func (s *Scheduler) Serve() {
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.cancelDone = make(chan struct{})
+
+	go func() {
+		defer close(s.cancelDone)
+
+		logger.Logger.Infof("Scheduler: started, poll interval: %v", s.PollInterval)
+
+		for {
+			if err := s.lead(); err != nil && s.ctx.Err() == nil {
+				logger.Logger.Errorf("Scheduler lead(): %v", err)
+			}
+
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(s.LeaderInterval):
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) Shutdown(ctx context.Context) {
+	if s.cancel == nil {
+		return
+	}
+	logger.Logger.Debug("Scheduler stopping on request...")
+	s.cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-s.cancelDone:
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+	logger.Logger.Info("Scheduler stopped")
+}
+
+// lead tries to take the advisory lock. If succeeded, the connection holding the
+// lock is kept and jobs are executed until the connection breaks or the scheduler stops.
+func (s *Scheduler) lead() error {
+	conn, err := s.DBPool.Acquire(s.ctx)
+	if err != nil {
+		return fmt.Errorf("DBPool.Acquire(): %v", err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(s.ctx, `SELECT pg_try_advisory_lock($1)`, s.LockKey).Scan(&locked); err != nil {
+		return fmt.Errorf("pg_try_advisory_lock: %v", err)
+	}
+	if !locked {
+		return nil
+	}
+
+	logger.Logger.Info("Scheduler: this instance is the leader")
+	s.isLeader.Store(true)
+	s.resetJobs(time.Now())
+	defer func() {
+		s.isLeader.Store(false)
+		// context might be cancelled
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, s.LockKey)
+	}()
+
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			// lock is held while the connection is alive
+			if _, err := conn.Exec(s.ctx, `SELECT 1`); err != nil {
+				return fmt.Errorf("leader connection lost: %v", err)
+			}
+			s.runDueJobs(now)
+			if err := s.failStale(); err != nil {
+				logger.Logger.Errorf("Scheduler failStale(): %v", err)
+			}
+			if err := s.runDelayed(); err != nil {
+				logger.Logger.Errorf("Scheduler runDelayed(): %v", err)
+			}
+		}
+	}
+}
+
+// resetJobs calculates next run time of all jobs from now on
+// as runs before the leadership might have been done by the previous leader.
+func (s *Scheduler) resetJobs(now time.Time) {
+	s.jobsMx.Lock()
+	for _, j := range s.jobs {
+		j.next = j.schedule.Next(now)
+	}
+	s.jobsMx.Unlock()
+}
+
+func (s *Scheduler) runDueJobs(now time.Time) {
+	s.jobsMx.Lock()
+	var due []*Job
+	for _, j := range s.jobs {
+		if j.next.IsZero() || j.next.After(now) {
+			continue
+		}
+		j.next = j.schedule.Next(now)
+		if j.running.Load() {
+			logger.Logger.Warnf("Scheduler: job %s is still running, skipping", j.ID)
+			continue
+		}
+		due = append(due, j)
+	}
+	s.jobsMx.Unlock()
+
+	for _, j := range due {
+		j.running.Store(true)
+		s.wg.Add(1)
+		go func(j *Job) {
+			defer s.wg.Done()
+			defer j.running.Store(false)
+
+			s.execute(j.ID, j.Service, j.Method, j.Params, s.SystemSession)
+		}(j)
+	}
+}
+
+// failStale fails delayed events left running by a crashed leader.
+// A running call is cancelled after JobTimeout, so events running
+// longer than JobTimeout plus a minute are not running anymore.
+// They are not retried as the call might have been done.
+func (s *Scheduler) failStale() error {
+	staleAfter := s.JobTimeout + time.Minute
+	cmd, err := s.DBPool.Exec(s.ctx,
+		`UPDATE `+scheduledEventsRelation+`
+		SET status = $1, error_text = $2, finished_at = now()
+		WHERE status = $3 AND started_at < now() - $4 * interval '1 millisecond'`,
+		StatusFailed, "interrupted: no result within the job timeout", StatusRunning, staleAfter.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("UPDATE: %v", err)
+	}
+	if cmd.RowsAffected() > 0 {
+		logger.Logger.Warnf("Scheduler: %d stale running delayed events failed", cmd.RowsAffected())
+	}
+	return nil
+}
+
+// runDelayed takes due delayed events and executes them.
+// Events are locked with SKIP LOCKED so several schedulers can never take the same event.
+func (s *Scheduler) runDelayed() error {
+	rows, err := s.DBPool.Query(s.ctx,
+		`UPDATE `+scheduledEventsRelation+`
+		SET status = $1, started_at = now()
+		WHERE id IN (
+			SELECT id FROM `+scheduledEventsRelation+`
+			WHERE status = $2 AND run_at <= now()
+			ORDER BY run_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, service, method, params, coalesce(session_id, '')`,
+		StatusRunning, StatusPending, delayedBatchSize,
+	)
+	if err != nil {
+		return fmt.Errorf("UPDATE: %v", err)
+	}
+
+	type delayed struct {
+		id              int64
+		service, method string
+		params          []string
+		sessionID       string
+	}
+	var list []delayed
+	for rows.Next() {
+		d := delayed{}
+		if err := rows.Scan(&d.id, &d.service, &d.method, &d.params, &d.sessionID); err != nil {
+			rows.Close()
+			return fmt.Errorf("rows.Scan(): %v", err)
+		}
+		list = append(list, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range list {
+		s.wg.Add(1)
+		go func(d delayed) {
+			defer s.wg.Done()
+
+			jobID := fmt.Sprintf("delayed:%d", d.id)
+			var status Status
+			var errText string
+			if userSess, err := s.delayedSession(d.sessionID, d.service, d.method); err != nil {
+				logger.Logger.Errorf("Scheduler: %s: %s.%s: %v", jobID, d.service, d.method, err)
+				status, errText = StatusFailed, err.Error()
+			} else {
+				status, errText = s.execute(jobID, d.service, d.method, d.params, userSess)
+			}
+			if _, err := s.DBPool.Exec(context.Background(),
+				`UPDATE `+scheduledEventsRelation+`
+				SET status = $2, error_text = $3, finished_at = now()
+				WHERE id = $1`,
+				d.id, status, errText,
+			); err != nil {
+				logger.Logger.Errorf("Scheduler delayed event %d status UPDATE: %v", d.id, err)
+			}
+		}(d)
+	}
+
+	return nil
+}
+
+// delayedSession returns the session of the user who queued the call
+// and checks the permission again, the system session for internal calls.
+func (s *Scheduler) delayedSession(sessionID, service, method string) (session.Session, error) {
+	if sessionID == "" {
+		return s.SystemSession, nil
+	}
+	if s.SessionLoader == nil {
+		return nil, fmt.Errorf("%w: SessionLoader is not set", ErrMethodNotAllowed)
+	}
+	userSess, err := s.SessionLoader(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("SessionLoader(): %v", err)
+	}
+	if err := s.checkMethod(userSess, service, method); err != nil {
+		return nil, err
+	}
+	return userSess, nil
+}
+
+// execute calls the service method with the session and writes history.
+func (s *Scheduler) execute(jobID, service, method string, params []string, userSess session.Session) (Status, string) {
+	logger.Logger.Debugf("Scheduler: running %s: %s.%s with params %v", jobID, service, method, params)
+
+	startedAt := time.Now()
+	status := StatusDone
+	var errText string
+	if err := s.call(service, method, params, userSess); err != nil {
+		logger.Logger.Errorf("Scheduler: %s: %s.%s failed: %v", jobID, service, method, err)
+		status = StatusFailed
+		errText = err.Error()
+	}
+
+	if _, err := s.DBPool.Exec(context.Background(),
+		`INSERT INTO `+historyRelation+`
+		(job_id, service, method, params, started_at, finished_at, status, error_text)
+		VALUES ($1, $2, $3, $4, $5, now(), $6, $7)`,
+		jobID, service, method, params, startedAt, status, errText,
+	); err != nil {
+		logger.Logger.Errorf("Scheduler history INSERT: %v", err)
+	}
+
+	return status, errText
+}
+
+func (s *Scheduler) call(service, method string, params []string, userSess session.Session) error {
+	ctx, cancel := context.WithTimeout(s.ctx, s.JobTimeout)
+	defer cancel()
+
+	results, err := api.CallMethod(ctx, service, method, params,
+		&api.ServiceContext{DB: database.DB, Session: userSess},
+	)
+	if err != nil {
+		return err
+	}
+	return api.ResultError(results)
+}
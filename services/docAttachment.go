@@ -15,10 +15,13 @@ import (
 	"github.com/dronm/session"
 
 	"github.com/dronm/gobizapp/models"
+	"github.com/dronm/gobizapp/notif"
 )
 
 const (
 	CacheDir = "CACHE"
+
+	attachmentChunkSize = 1 << 20 // content_data bytes read at once for notifications
 )
 
 type DocAttachmentService struct {
@@ -316,3 +319,100 @@ func (s *DocAttachmentService) GetFile(ctx context.Context, ref models.Ref, cont
 	return
 }
 
+// NotifAttachment returns an email attachment of the file, the content
+// is read from the database at sending time.
+func (s *DocAttachmentService) NotifAttachment(ctx context.Context, ref models.Ref, contentID string) (notif.Attachment, error) {
+	if ref.DataType == nil || ref.Keys.ID == 0 {
+		return notif.Attachment{}, fmt.Errorf("ref not set")
+	}
+
+	poolConn, connID, err := s.DB.GetSecondary("")
+	if err != nil {
+		return notif.Attachment{}, fmt.Errorf("GetSecondary() failed: %v", err)
+	}
+	defer s.DB.Release(poolConn, connID)
+	conn := poolConn.Conn()
+
+	var attID int64
+	var attachmentName string
+	if err := conn.QueryRow(ctx,
+		`SELECT
+			id,
+			coalesce(content_info->>'name', '')
+		FROM attachments
+		WHERE ref->>'dataType' = $1
+			AND (ref->'keys'->>'id')::int = $2
+			AND content_info->>'id' = $3`,
+		ref.DataType,
+		ref.Keys.ID,
+		contentID,
+	).Scan(&attID, &attachmentName); err != nil {
+		return notif.Attachment{}, fmt.Errorf("conn.QueryRow() select failed: %v", err)
+	}
+	if attachmentName == "" {
+		attachmentName = contentID
+	}
+
+	return notif.NewAttachment(attachmentName, func(ctx context.Context) (io.ReadCloser, error) {
+		return &attachmentReader{ctx: ctx, db: s.DB, attID: attID}, nil
+	}), nil
+}
+
+// attachmentReader reads content_data in chunks, so the whole file
+// is never held in memory. A connection is taken for every chunk only.
+type attachmentReader struct {
+	ctx    context.Context
+	db     *pgds.PgProvider
+	attID  int64
+	offset int64
+	buf    []byte
+	eof    bool
+}
+
+func (r *attachmentReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fetch(); err != nil {
+			return 0, err
+		}
+		if len(r.buf) == 0 {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *attachmentReader) fetch() error {
+	poolConn, connID, err := r.db.GetSecondary("")
+	if err != nil {
+		return fmt.Errorf("GetSecondary() failed: %v", err)
+	}
+	defer r.db.Release(poolConn, connID)
+
+	var chunk []byte
+	if err := poolConn.Conn().QueryRow(r.ctx,
+		`SELECT
+			substring(content_data from $2 for $3)
+		FROM attachments
+		WHERE id = $1`,
+		r.attID,
+		r.offset+1,
+		attachmentChunkSize,
+	).Scan(&chunk); err != nil {
+		return fmt.Errorf("conn.QueryRow() select content_data failed: %v", err)
+	}
+	r.offset += int64(len(chunk))
+	r.eof = len(chunk) < attachmentChunkSize
+	r.buf = chunk
+	return nil
+}
+
+func (r *attachmentReader) Close() error {
+	r.buf = nil
+	r.eof = true
+	return nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 
 	"github.com/dronm/ds/pgds"
@@ -17,6 +18,27 @@ type EventHandler interface {
 }
 var EvHandler EventHandler
 
+var ErrClientRequestNotSupported = errors.New("EventService: EvHandler does not support client requests")
+
+// ClientRequester is implemented by event handlers which can ask
+// a client something and wait for the answer.
+type ClientRequester interface {
+	RequestSession(ctx context.Context, sessionID, method string, payload any) (json.RawMessage, error)
+}
+
+// RequestClient sends a request to all connections of the session
+// and returns the first reply.
+func RequestClient(ctx context.Context, sessionID, method string, payload any) (json.RawMessage, error) {
+	if EvHandler == nil {
+		return nil, ErrEvHandlerNotDefined
+	}
+	req, ok := EvHandler.(ClientRequester)
+	if !ok {
+		return nil, ErrClientRequestNotSupported
+	}
+	return req.RequestSession(ctx, sessionID, method, payload)
+}
+
 type EventService struct {
 	DB      *pgds.PgProvider
 	Session session.Session
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	crud "github.com/dronm/crudifier"
+	crudTypes "github.com/dronm/crudifier/types"
+	"github.com/dronm/ds/pgds"
+	"github.com/dronm/session"
+
+	"github.com/dronm/gobizapp/models"
+)
+
+var ErrLocalEvDispatcherNotDefined = errors.New("EventDeadLetterService: LocalEvDispatcher not set")
+
+// LocalEventDispatcher runs local event consumers, implemented by eventServer.EventServer.
+type LocalEventDispatcher interface {
+	DispatchLocalEvent(eventID, payload string) error
+}
+
+var LocalEvDispatcher LocalEventDispatcher
+
+// EventDeadLetterService is a service for managing failed local events.
+type EventDeadLetterService struct {
+	DB      *pgds.PgProvider
+	Session session.Session
+	QueryID string
+}
+
+func (s *EventDeadLetterService) SetDB(db *pgds.PgProvider) {
+	s.DB = db
+}
+
+func (s *EventDeadLetterService) SetSession(sess session.Session) {
+	s.Session = sess
+}
+
+func (s *EventDeadLetterService) SetQueryID(queryID string) {
+	s.QueryID = queryID
+}
+
+func NewEventDeadLetterService(db *pgds.PgProvider, sess session.Session) *EventDeadLetterService {
+	return &EventDeadLetterService{DB: db, Session: sess}
+}
+
+func (s *EventDeadLetterService) FetchList(ctx context.Context, params crud.CollectionParams) ([]*models.EventDeadLetter, *models.TotCount, error) {
+	return FetchCollectionModel(ctx, s.DB, &models.EventDeadLetter{}, &models.TotCount{}, params)
+}
+
+func (s *EventDeadLetterService) FetchDetail(ctx context.Context, id int) (*models.EventDeadLetter, error) {
+	model := models.EventDeadLetter{}
+	if err := FetchModel(ctx, s.DB, &models.EventDeadLetterKey{ID: id}, &model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// Retry dispatches events again and removes them from the table.
+// If a consumer fails again, a new dead letter is stored.
+func (s *EventDeadLetterService) Retry(ctx context.Context, keyModels []models.EventDeadLetterKey) (int64, error) {
+	if LocalEvDispatcher == nil {
+		return 0, ErrLocalEvDispatcherNotDefined
+	}
+
+	var cnt int64
+	for _, key := range keyModels {
+		model, err := s.FetchDetail(ctx, key.ID)
+		if err != nil {
+			return cnt, fmt.Errorf("FetchDetail(%d): %v", key.ID, err)
+		}
+		// the dead letter is kept if the event could not be dispatched
+		if err := LocalEvDispatcher.DispatchLocalEvent(model.EventID, model.Payload); err != nil {
+			return cnt, fmt.Errorf("DispatchLocalEvent(%s): %v", model.EventID, err)
+		}
+		if _, err := s.Discard(ctx, []models.EventDeadLetterKey{key}); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+
+	return cnt, nil
+}
+
+// Discard deletes events without processing.
+func (s *EventDeadLetterService) Discard(ctx context.Context, keyModels []models.EventDeadLetterKey) (int64, error) {
+	models := make([]crudTypes.DbModel, len(keyModels))
+	for i, m := range keyModels {
+		models[i] = m
+	}
+	return DeleteModel(ctx, s.DB, models, nil)
+}
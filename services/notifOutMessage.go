@@ -2,14 +2,32 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	crud "github.com/dronm/crudifier"
 	"github.com/dronm/ds/pgds"
 	"github.com/dronm/session"
 
+	"github.com/dronm/gobizapp/logger"
 	"github.com/dronm/gobizapp/models"
+	"github.com/dronm/gobizapp/notif"
+	"github.com/jackc/pgx/v5"
 )
 
+const EventNotifOutMessageStatusChanged = "NotifOutMessage.StatusChanged"
+
+var ErrNotifCallbackSignature = errors.New("notification callback signature is invalid")
+
+// NotifOutMessageStatusChanged is a payload of the status changed event.
+type NotifOutMessageStatusChanged struct {
+	ID       int    `json:"id"`
+	Status   int    `json:"status"`
+	Closed   bool   `json:"closed"`
+	Provider string `json:"provider"`
+	Error    string `json:"error"`
+}
+
 type NotifOutMessageService struct {
 	DB      *pgds.PgProvider
 	Session session.Session
@@ -39,3 +57,134 @@ func (s *NotifOutMessageService) FetchDetail(ctx context.Context, id int) (*mode
 	return &model, nil
 }
 
+// FetchAttempts returns outbox delivery attempts of the message.
+func (s *NotifOutMessageService) FetchAttempts(ctx context.Context, id int, params crud.CollectionParams) ([]*models.NotifOutMessageAttempt, *models.TotCount, error) {
+	// message must belong to the application
+	if _, err := s.FetchDetail(ctx, id); err != nil {
+		return nil, nil, err
+	}
+	params.Filter = append(params.Filter,
+		crud.CollectionFilter{
+			Join:   crud.FILTER_PAR_JOIN_AND,
+			Fields: map[string]crud.CollectionFilterField{"out_message_id": {Operator: crud.FILTER_OPER_PAR_E, Value: id}},
+		},
+	)
+
+	return FetchCollectionModel(ctx, s.DB, &models.NotifOutMessageAttempt{}, &models.TotCount{}, params)
+}
+
+// FetchStatusHistory returns statuses of the message reported by callbacks.
+func (s *NotifOutMessageService) FetchStatusHistory(ctx context.Context, id int, params crud.CollectionParams) ([]*models.NotifOutMessageStatus, *models.TotCount, error) {
+	if _, err := s.FetchDetail(ctx, id); err != nil {
+		return nil, nil, err
+	}
+	params.Filter = append(params.Filter,
+		crud.CollectionFilter{
+			Join:   crud.FILTER_PAR_JOIN_AND,
+			Fields: map[string]crud.CollectionFilterField{"out_message_id": {Operator: crud.FILTER_OPER_PAR_E, Value: id}},
+		},
+	)
+
+	return FetchCollectionModel(ctx, s.DB, &models.NotifOutMessageStatus{}, &models.TotCount{}, params)
+}
+
+// ApplyCallback verifies the body signature with the application callback key,
+// stores reported statuses and publishes the status changed event for every message.
+// The signature covers the timestamp, stale timestamps are rejected as replays.
+// It returns the number of applied reports, reports of unknown messages are skipped.
+func (s *NotifOutMessageService) ApplyCallback(ctx context.Context, body []byte, signature, timestamp string) (int, error) {
+	app := models.NotifApp{}
+	if err := FetchModel(ctx, s.DB, &models.NotifAppKey{Id: NotifAppID()}, &app); err != nil {
+		return 0, fmt.Errorf("FetchModel(): %v", err)
+	}
+	if !notif.VerifyTimestampSignature(app.CallbackKey, body, signature, timestamp, notif.DefSignatureMaxAge) {
+		return 0, ErrNotifCallbackSignature
+	}
+	reports, err := notif.ParseStatusReports(body)
+	if err != nil {
+		return 0, fmt.Errorf("notif.ParseStatusReports(): %v", err)
+	}
+
+	poolConn, connID, err := s.DB.GetPrimary()
+	if err != nil {
+		return 0, fmt.Errorf("GetPrimary() failed: %v", err)
+	}
+	defer s.DB.Release(poolConn, connID)
+	conn := poolConn.Conn()
+
+	var applied int
+	for _, r := range reports {
+		ev, err := applyStatusReport(ctx, conn, r)
+		if err != nil {
+			return applied, err
+		}
+		if ev == nil {
+			logger.Logger.Warnf("NotifOutMessageService ApplyCallback: message %d not found", r.ID)
+			continue
+		}
+		applied++
+
+		if EvHandler != nil {
+			if err := EvHandler.PublishEvent("", EventNotifOutMessageStatusChanged, ev); err != nil {
+				logger.Logger.Errorf("NotifOutMessageService ApplyCallback PublishEvent(): %v", err)
+			}
+		}
+	}
+	return applied, nil
+}
+
+// frozenOutStatuses are the statuses a report never changes.
+var frozenOutStatuses = []int{
+	int(notif.OutStatusDelivered),
+	int(notif.OutStatusRejected),
+	int(notif.OutStatusFailed),
+	int(notif.OutStatusSuppressed),
+}
+
+// reportOutStatuses are the statuses a provider report may set on a closed message.
+var reportOutStatuses = []int{
+	int(notif.OutStatusDelivered),
+	int(notif.OutStatusRejected),
+}
+
+// applyStatusReport updates message status and writes the history in one transaction.
+// A sent message may still become delivered or rejected, any other report on a closed
+// message and any report on a frozen status is written to the history only.
+func applyStatusReport(ctx context.Context, conn *pgx.Conn, r notif.StatusReport) (*NotifOutMessageStatusChanged, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("conn.Begin(): %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ev := &NotifOutMessageStatusChanged{ID: r.ID, Status: int(r.Status), Provider: r.Provider, Error: r.Error}
+	if err := tx.QueryRow(ctx,
+		`UPDATE notifications.out_messages
+		SET status = CASE
+				WHEN status = ANY($5) THEN status
+				WHEN closed AND NOT $3 = ANY($6) THEN status
+				ELSE $3
+			END,
+			closed = closed OR $4
+		WHERE id = $1 AND app_id = $2
+		RETURNING status, closed`,
+		r.ID, NotifAppID(), int(r.Status), r.Status.Final(), frozenOutStatuses, reportOutStatuses,
+	).Scan(&ev.Status, &ev.Closed); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("UPDATE: %v", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO notifications.out_message_statuses
+		(out_message_id, status, provider, error_text, reported_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		r.ID, r.Status, r.Provider, r.Error, r.ReportedAt,
+	); err != nil {
+		return nil, fmt.Errorf("INSERT: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("tx.Commit(): %v", err)
+	}
+	return ev, nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dronm/ds/pgds"
+	"github.com/dronm/session"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/dronm/gobizapp/models"
+	"github.com/dronm/gobizapp/notif"
+)
+
+var ErrNotifPrefsNotInitialized = errors.New("notification preferences are not initialized")
+
+// notifUnsubscribeSecret signs unsubscribe tokens, see InitNotifPrefs.
+var notifUnsubscribeSecret string
+
+// InitNotifPrefs makes the notifier apply recipient preferences.
+// The table is created with database.ApplySchema(ctx, db, "notif_prefs.sql").
+// Mandatory message types ignore opt-ins, unsubscriptions and quiet hours.
+func InitNotifPrefs(db *pgds.PgProvider, unsubscribeSecret string, mandatory ...string) {
+	if stekloNotifier == nil {
+		stekloNotifier = notif.NewNotifier("", "", "")
+	}
+	notifUnsubscribeSecret = unsubscribeSecret
+	stekloNotifier.Prefs = &notif.PrefsFilter{Store: &notifPrefStore{db: db}, Mandatory: mandatory}
+}
+
+type notifPrefStore struct {
+	db *pgds.PgProvider
+}
+
+func (st *notifPrefStore) Preferences(ctx context.Context, recipientID string) (*notif.Preferences, error) {
+	model := models.NotifRecipientPref{}
+	if err := FetchModel(ctx, st.db, &models.NotifRecipientPrefKey{AppID: NotifAppID(), RecipientID: recipientID}, &model); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return notifPrefsFromModel(&model), nil
+}
+
+func notifPrefsFromModel(m *models.NotifRecipientPref) *notif.Preferences {
+	p := &notif.Preferences{
+		RecipientID:  m.RecipientID,
+		TimeZone:     m.TimeZone,
+		Unsubscribed: m.Unsubscribed,
+	}
+	if m.OptIns != nil {
+		p.OptIns = make(map[string][]notif.NotifProvider, len(m.OptIns))
+		for tp, provs := range m.OptIns {
+			list := make([]notif.NotifProvider, 0, len(provs))
+			for _, prov := range provs {
+				list = append(list, notif.NotifProvider(prov))
+			}
+			p.OptIns[tp] = list
+		}
+	}
+	for _, prov := range m.ProviderOrder {
+		p.ProviderOrder = append(p.ProviderOrder, notif.NotifProvider(prov))
+	}
+	for _, q := range m.QuietHours {
+		p.QuietHours = append(p.QuietHours, notif.QuietHours{From: q.From, To: q.To})
+	}
+	return p
+}
+
+// NotifRecipientPrefService manages recipient delivery preferences.
+type NotifRecipientPrefService struct {
+	DB      *pgds.PgProvider
+	Session session.Session
+}
+
+func NewNotifRecipientPrefService(db *pgds.PgProvider, sess session.Session) *NotifRecipientPrefService {
+	return &NotifRecipientPrefService{DB: db, Session: sess}
+}
+
+func (s *NotifRecipientPrefService) FetchDetail(ctx context.Context, recipientID string) (*models.NotifRecipientPref, error) {
+	model := models.NotifRecipientPref{}
+	if err := FetchModel(ctx, s.DB, &models.NotifRecipientPrefKey{AppID: NotifAppID(), RecipientID: recipientID}, &model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
+
+// Update inserts or replaces recipient preferences.
+func (s *NotifRecipientPrefService) Update(ctx context.Context, model models.NotifRecipientPref) error {
+	if model.RecipientID == "" {
+		return fmt.Errorf("recipient ID is not defined")
+	}
+	prefs := notifPrefsFromModel(&model)
+	// checks quiet hours format and time zone
+	if _, err := prefs.QuietUntil(time.Now()); err != nil {
+		return fmt.Errorf("QuietUntil(): %v", err)
+	}
+
+	poolConn, connID, err := s.DB.GetPrimary()
+	if err != nil {
+		return fmt.Errorf("GetPrimary() failed: %v", err)
+	}
+	defer s.DB.Release(poolConn, connID)
+
+	if _, err := poolConn.Conn().Exec(ctx,
+		`INSERT INTO notifications.recipient_prefs
+		(app_id, recipient_id, opt_ins, provider_order, quiet_hours, time_zone, unsubscribed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (app_id, recipient_id) DO UPDATE SET
+			opt_ins = EXCLUDED.opt_ins,
+			provider_order = EXCLUDED.provider_order,
+			quiet_hours = EXCLUDED.quiet_hours,
+			time_zone = EXCLUDED.time_zone,
+			unsubscribed = EXCLUDED.unsubscribed`,
+		NotifAppID(), model.RecipientID, model.OptIns, model.ProviderOrder,
+		model.QuietHours, model.TimeZone, model.Unsubscribed,
+	); err != nil {
+		return fmt.Errorf("INSERT: %v", HandlePgxError(err))
+	}
+	return nil
+}
+
+// UnsubscribeToken returns a token for unsubscribe links,
+// messageType "*" unsubscribes from all message types.
+func (s *NotifRecipientPrefService) UnsubscribeToken(ctx context.Context, recipientID, messageType string) (string, error) {
+	if notifUnsubscribeSecret == "" {
+		return "", ErrNotifPrefsNotInitialized
+	}
+	return notif.UnsubscribeToken(notifUnsubscribeSecret, recipientID, messageType), nil
+}
+
+// Unsubscribe adds the message type of the token to recipient unsubscriptions.
+func (s *NotifRecipientPrefService) Unsubscribe(ctx context.Context, token string) error {
+	if notifUnsubscribeSecret == "" {
+		return ErrNotifPrefsNotInitialized
+	}
+	recipientID, messageType, err := notif.ParseUnsubscribeToken(notifUnsubscribeSecret, token)
+	if err != nil {
+		return err
+	}
+
+	poolConn, connID, err := s.DB.GetPrimary()
+	if err != nil {
+		return fmt.Errorf("GetPrimary() failed: %v", err)
+	}
+	defer s.DB.Release(poolConn, connID)
+
+	if _, err := poolConn.Conn().Exec(ctx,
+		`INSERT INTO notifications.recipient_prefs
+		(app_id, recipient_id, unsubscribed)
+		VALUES ($1, $2, ARRAY[$3::text])
+		ON CONFLICT (app_id, recipient_id) DO UPDATE SET
+			unsubscribed = CASE
+				WHEN $3 = ANY(coalesce(recipient_prefs.unsubscribed, '{}')) THEN recipient_prefs.unsubscribed
+				ELSE array_append(coalesce(recipient_prefs.unsubscribed, '{}'), $3)
+			END`,
+		NotifAppID(), recipientID, messageType,
+	); err != nil {
+		return fmt.Errorf("INSERT: %v", err)
+	}
+	return nil
+}
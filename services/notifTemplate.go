@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"fmt"
 
 	crud "github.com/dronm/crudifier"
 	fields "github.com/dronm/crudifier/fields"
@@ -10,8 +11,63 @@ import (
 	"github.com/dronm/session"
 
 	"github.com/dronm/gobizapp/models"
+	"github.com/dronm/gobizapp/notif"
 )
 
+// NotifRenderer renders notif_templates records, see InitNotifRenderer.
+var NotifRenderer *notif.Renderer
+
+// InitNotifRenderer creates the renderer loading templates from db.
+func InitNotifRenderer(db *pgds.PgProvider) *notif.Renderer {
+	NotifRenderer = notif.NewRenderer(&notifTemplateLoader{db: db})
+	return NotifRenderer
+}
+
+type notifTemplateLoader struct {
+	db *pgds.PgProvider
+}
+
+func (l *notifTemplateLoader) LoadTemplate(ctx context.Context, notifType string, prov notif.NotifProvider) (*notif.Template, error) {
+	params := crud.CollectionParams{
+		Count: 1,
+		Filter: []crud.CollectionFilter{
+			{
+				Join: crud.FILTER_PAR_JOIN_AND,
+				Fields: map[string]crud.CollectionFilterField{
+					"notif_type":     {Operator: crud.FILTER_OPER_PAR_E, Value: notifType},
+					"notif_provider": {Operator: crud.FILTER_OPER_PAR_E, Value: string(prov)},
+				},
+			},
+		},
+	}
+	list, _, err := FetchCollectionModel(ctx, l.db, &models.NotifTemplate{}, &models.TotCount{}, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return notifTemplateFromModel(list[0]), nil
+}
+
+func notifTemplateFromModel(m *models.NotifTemplate) *notif.Template {
+	t := &notif.Template{
+		Provider: notif.NotifProvider(m.NotifProvider.GetValue()),
+		Type:     m.NotifType.GetValue(),
+		Text:     m.Template.GetValue(),
+		Values:   make(map[string]string),
+	}
+	for _, f := range m.Fields {
+		t.Fields = append(t.Fields, f.Id)
+	}
+	if m.ProviderValues != nil {
+		for _, v := range *m.ProviderValues {
+			t.Values[v.Id] = v.Val
+		}
+	}
+	return t
+}
+
 // ProductCatService is a service for managing product categories
 type NotifTemplateService struct {
 	DB      *pgds.PgProvider
@@ -62,3 +118,22 @@ func (s *NotifTemplateService) Insert(ctx context.Context, model models.NotifTem
 	return retFields, nil
 }
 
+// Preview renders a template from the editor. If data is empty, every field
+// is replaced with its name.
+func (s *NotifTemplateService) Preview(ctx context.Context, model models.NotifTemplate, data map[string]any) (*notif.RenderedTemplate, error) {
+	t := notifTemplateFromModel(&model)
+	if len(data) == 0 {
+		data = t.SampleData()
+	} else if err := t.Validate(data); err != nil {
+		return nil, err
+	}
+	renderer := NotifRenderer
+	if renderer == nil {
+		renderer = notif.NewRenderer(nil)
+	}
+	res, err := renderer.Execute(t, data)
+	if err != nil {
+		return nil, fmt.Errorf("Execute(): %v", err)
+	}
+	return res, nil
+}
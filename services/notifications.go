@@ -1,13 +1,16 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/dronm/gobizapp/notif"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Здесь собраны функции отправки различных сообщений
@@ -15,9 +18,23 @@ import (
 var stekloNotifier *notif.Notifier
 
 func InitNotifier(host, appName, pwd string) {
+	if stekloNotifier != nil {
+		// keep registered drivers
+		stekloNotifier.Host, stekloNotifier.AppName, stekloNotifier.Pwd = host, appName, pwd
+		return
+	}
 	stekloNotifier = notif.NewNotifier(host, appName, pwd)
 }
 
+// RegisterNotifProvider sets a direct delivery driver for the provider.
+// Without InitNotifier all messages must be covered by drivers.
+func RegisterNotifProvider(prov notif.NotifProvider, p notif.Provider) {
+	if stekloNotifier == nil {
+		stekloNotifier = notif.NewNotifier("", "", "")
+	}
+	stekloNotifier.RegisterProvider(prov, p)
+}
+
 func NotifSend(batch []*notif.NotifMessage) error {
 	if stekloNotifier == nil {
 		return fmt.Errorf("stekloNotifier is not initialized")
@@ -42,6 +59,55 @@ func NotifSend(batch []*notif.NotifMessage) error {
 	return nil
 }
 
+// NotifOutbox delivers messages in background with retries, see InitNotifOutbox.
+var NotifOutbox *notif.Outbox
+
+// InitNotifOutbox creates the outbox for the notifier, it should be called
+// after InitNotifier and RegisterNotifProvider. The outbox is started with Serve().
+func InitNotifOutbox(dbPool *pgxpool.Pool) *notif.Outbox {
+	if stekloNotifier == nil {
+		stekloNotifier = notif.NewNotifier("", "", "")
+	}
+	NotifOutbox = notif.NewOutbox(dbPool, stekloNotifier, NotifAppID())
+	// messages in quiet hours are stored until the end of the window,
+	// messages limited by NotifOutbox.Policy are delivered by the outbox
+	stekloNotifier.Defer = func(ctx context.Context, m *notif.NotifMessage) error {
+		_, err := NotifOutbox.Enqueue(ctx, nil, []*notif.NotifMessage{m})
+		return err
+	}
+	stekloNotifier.Policed = func(m *notif.NotifMessage) bool {
+		return NotifOutbox.Policy.Applies(m)
+	}
+	return NotifOutbox
+}
+
+// NotifDigestRule returns an outbox digest rule rendering digestType
+// notif_templates with NotifRenderer, templates get count and items fields.
+// Set it in NotifOutbox.Policy.Digests by the merged message type.
+func NotifDigestRule(digestType string, window time.Duration) notif.DigestRule {
+	return notif.DigestRule{
+		Window: window,
+		Build: func(ctx context.Context, msgs []*notif.NotifMessage) (*notif.NotifMessage, error) {
+			if NotifRenderer == nil {
+				return nil, errors.New("notification renderer is not initialized")
+			}
+			return notif.TemplateDigest(NotifRenderer, digestType)(ctx, msgs)
+		},
+	}
+}
+
+// NotifEnqueue stores the batch in the outbox with conn, so messages are sent
+// only if the current transaction commits. Without the outbox the batch is sent at once.
+func NotifEnqueue(ctx context.Context, conn notif.DBQuerier, batch []*notif.NotifMessage) error {
+	if NotifOutbox == nil {
+		return NotifSend(batch)
+	}
+	if _, err := NotifOutbox.Enqueue(ctx, conn, batch); err != nil {
+		return fmt.Errorf("NotifOutbox.Enqueue(): %v", err)
+	}
+	return nil
+}
+
 func RecoverPasswordEmail(conn *pgx.Conn, userId int, url string, newPassword string) error {
 	msg, err := notif.NewEmailMessageFromSQL(conn, "email_recover_pwd", nil, nil, []any{userId, url, newPassword})
 	if err != nil {
@@ -50,12 +116,14 @@ func RecoverPasswordEmail(conn *pgx.Conn, userId int, url string, newPassword st
 
 	notifMsg := notif.NewNotifMessage("confirm", notif.PROV_EMAIL)
 	notifMsg.AddEmailMessage(msg)
-	return NotifSend([]*notif.NotifMessage{notifMsg})
+	return NotifEnqueue(context.Background(), conn, []*notif.NotifMessage{notifMsg})
 }
 
+// CorrectTelForSMS normalizes tel to E.164 with notif.DefaultCountry,
+// an invalid number is left as is.
 func CorrectTelForSMS(tel *string) {
-	if len(*tel) == 10 {
-		*tel = "7" + *tel
+	if norm, err := notif.NormalizeTel(*tel, notif.DefaultCountry); err == nil {
+		*tel = norm
 	}
 }
 
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	crud "github.com/dronm/crudifier"
+	"github.com/dronm/ds/pgds"
+	"github.com/dronm/session"
+
+	"github.com/dronm/gobizapp/errs"
+	"github.com/dronm/gobizapp/models"
+	"github.com/dronm/gobizapp/scheduler"
+)
+
+var ErrJobSchedulerNotDefined = errors.New("SchedulerService: JobScheduler not set")
+
+// JobSchedulerHandler is implemented by scheduler.Scheduler.
+type JobSchedulerHandler interface {
+	Jobs() []scheduler.JobInfo
+	IsLeader() bool
+	Delay(ctx context.Context, runAt time.Time, service, method string, params ...string) (int64, error)
+	DelayAs(ctx context.Context, userSess session.Session, runAt time.Time, service, method string, params ...string) (int64, error)
+	Cancel(ctx context.Context, id int64) error
+}
+
+var JobScheduler JobSchedulerHandler
+
+// SchedulerService gives access to scheduled jobs, delayed events and execution history.
+type SchedulerService struct {
+	DB      *pgds.PgProvider
+	Session session.Session
+	QueryID string
+}
+
+func (s *SchedulerService) SetDB(db *pgds.PgProvider) {
+	s.DB = db
+}
+
+func (s *SchedulerService) SetSession(sess session.Session) {
+	s.Session = sess
+}
+
+func (s *SchedulerService) SetQueryID(queryID string) {
+	s.QueryID = queryID
+}
+
+func NewSchedulerService(db *pgds.PgProvider, sess session.Session) *SchedulerService {
+	return &SchedulerService{DB: db, Session: sess}
+}
+
+// FetchJobs returns periodic jobs of this instance.
+func (s *SchedulerService) FetchJobs(ctx context.Context) ([]scheduler.JobInfo, error) {
+	if JobScheduler == nil {
+		return nil, ErrJobSchedulerNotDefined
+	}
+	return JobScheduler.Jobs(), nil
+}
+
+func (s *SchedulerService) FetchDelayedList(ctx context.Context, params crud.CollectionParams) ([]*models.ScheduledEvent, *models.TotCount, error) {
+	return FetchCollectionModel(ctx, s.DB, &models.ScheduledEvent{}, &models.TotCount{}, params)
+}
+
+func (s *SchedulerService) FetchHistoryList(ctx context.Context, params crud.CollectionParams) ([]*models.SchedulerHistory, *models.TotCount, error) {
+	return FetchCollectionModel(ctx, s.DB, &models.SchedulerHistory{}, &models.TotCount{}, params)
+}
+
+// Delay adds a one-off service call, params are method parameters in order.
+// The caller must be allowed to call the method, the call runs with the caller session.
+func (s *SchedulerService) Delay(ctx context.Context, runAt time.Time, service, method string, params []string) (int64, error) {
+	if JobScheduler == nil {
+		return 0, ErrJobSchedulerNotDefined
+	}
+	id, err := JobScheduler.DelayAs(ctx, s.Session, runAt, service, method, params...)
+	if errors.Is(err, scheduler.ErrMethodNotAllowed) {
+		return 0, errs.NewPublicError(errs.NotAllowed)
+	}
+	return id, err
+}
+
+// CancelDelayed cancels pending delayed calls.
+func (s *SchedulerService) CancelDelayed(ctx context.Context, keyModels []models.ScheduledEventKey) error {
+	if JobScheduler == nil {
+		return ErrJobSchedulerNotDefined
+	}
+	for _, k := range keyModels {
+		if err := JobScheduler.Cancel(ctx, int64(k.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
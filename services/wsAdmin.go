@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dronm/ds/pgds"
+	"github.com/dronm/session"
+)
+
+var ErrWSAdminNotDefined = errors.New("WSAdminService: WSAdmin not set")
+
+// WSConnInfo describes one live connection.
+type WSConnInfo struct {
+	ConnID      string    `json:"conn_id"`
+	Transport   string    `json:"transport"`
+	RemoteAddr  string    `json:"remote_addr"`
+	UserAgent   string    `json:"user_agent"`
+	ConnectedAt time.Time `json:"connected_at"`
+	VisitedAt   time.Time `json:"visited_at"`
+	Events      []string  `json:"events"`
+	NodeID      string    `json:"node_id,omitempty"`
+}
+
+// WSSessionInfo describes a connected session with all its connections.
+type WSSessionInfo struct {
+	SessionID   string       `json:"session_id"`
+	Role        string       `json:"role"`
+	ClientCount int          `json:"client_count"`
+	Clients     []WSConnInfo `json:"clients"`
+}
+
+// WSAdminHandler is implemented by ws.WSServer.
+type WSAdminHandler interface {
+	Sessions(ctx context.Context) []WSSessionInfo
+	DisconnectClient(sessionID, connID string) error
+	DisconnectSession(sessionID string) error
+	LogoutSession(sessionID string) error
+	Broadcast(eventID string, payload any, role string) (int, error)
+}
+
+var WSAdmin WSAdminHandler
+
+// WSAdminService gives operators access to live websocket connections.
+// Access to its methods is controlled with permissions as for any other service.
+type WSAdminService struct {
+	DB      *pgds.PgProvider
+	Session session.Session
+	QueryID string
+}
+
+func (s *WSAdminService) SetDB(db *pgds.PgProvider) {
+	s.DB = db
+}
+
+func (s *WSAdminService) SetSession(sess session.Session) {
+	s.Session = sess
+}
+
+func (s *WSAdminService) SetQueryID(queryID string) {
+	s.QueryID = queryID
+}
+
+func NewWSAdminService(db *pgds.PgProvider, sess session.Session) *WSAdminService {
+	return &WSAdminService{DB: db, Session: sess}
+}
+
+// FetchSessions returns connected sessions of all instances.
+func (s *WSAdminService) FetchSessions(ctx context.Context) ([]WSSessionInfo, error) {
+	if WSAdmin == nil {
+		return nil, ErrWSAdminNotDefined
+	}
+	return WSAdmin.Sessions(ctx), nil
+}
+
+// DisconnectClient closes one connection of the session.
+func (s *WSAdminService) DisconnectClient(ctx context.Context, sessionID, connID string) error {
+	if WSAdmin == nil {
+		return ErrWSAdminNotDefined
+	}
+	return WSAdmin.DisconnectClient(sessionID, connID)
+}
+
+// DisconnectSession closes all connections of the session.
+func (s *WSAdminService) DisconnectSession(ctx context.Context, sessionID string) error {
+	if WSAdmin == nil {
+		return ErrWSAdminNotDefined
+	}
+	return WSAdmin.DisconnectSession(sessionID)
+}
+
+// LogoutSession destroys the session and closes all its connections.
+func (s *WSAdminService) LogoutSession(ctx context.Context, sessionID string) error {
+	if WSAdmin == nil {
+		return ErrWSAdminNotDefined
+	}
+	return WSAdmin.LogoutSession(sessionID)
+}
+
+// Broadcast sends the event to all connections, or to connections
+// of the given role only if role is not empty.
+// It returns the number of connections of this instance the event was sent to.
+func (s *WSAdminService) Broadcast(ctx context.Context, eventID string, payload any, role string) (int, error) {
+	if WSAdmin == nil {
+		return 0, ErrWSAdminNotDefined
+	}
+	return WSAdmin.Broadcast(eventID, payload, role)
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dronm/session"
+)
+
+// SystemSessionID is an ID of the session used for internal service calls:
+// local events, scheduled jobs.
+const SystemSessionID = "system"
+
+// MemSession is an in-memory session.Session implementation.
+// It is never persisted, Flush does nothing.
+type MemSession struct {
+	mx           sync.RWMutex
+	id           string
+	values       map[string]any
+	timeCreated  time.Time
+	timeAccessed time.Time
+}
+
+func NewMemSession(id string) *MemSession {
+	now := time.Now()
+	return &MemSession{id: id, values: make(map[string]any), timeCreated: now, timeAccessed: now}
+}
+
+// NewSystemSession returns a session for internal service calls.
+// The session has "system" key set to true.
+func NewSystemSession() *MemSession {
+	s := NewMemSession(SystemSessionID)
+	_ = s.Set("system", true)
+	return s
+}
+
+func (s *MemSession) Set(key string, value any) error {
+	s.mx.Lock()
+	s.values[key] = value
+	s.timeAccessed = time.Now()
+	s.mx.Unlock()
+	return nil
+}
+
+func (s *MemSession) Put(key string, value any) error {
+	return s.Set(key, value)
+}
+
+// Get sets value pointer to the stored value. If types differ,
+// the value is converted through json.
+func (s *MemSession) Get(key string, value any) error {
+	s.mx.RLock()
+	v, ok := s.values[key]
+	s.mx.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	dst := reflect.ValueOf(value)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("MemSession Get(): value must be a non nil pointer")
+	}
+	src := reflect.ValueOf(v)
+	if src.IsValid() && src.Type().AssignableTo(dst.Elem().Type()) {
+		dst.Elem().Set(src)
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, value)
+}
+
+func (s *MemSession) GetBool(key string) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	v, _ := s.values[key].(bool)
+	return v
+}
+
+func (s *MemSession) GetString(key string) string {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	v, _ := s.values[key].(string)
+	return v
+}
+
+func (s *MemSession) GetInt(key string) int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	switch v := s.values[key].(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	}
+	return 0
+}
+
+func (s *MemSession) GetFloat(key string) float64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	switch v := s.values[key].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	}
+	return 0
+}
+
+func (s *MemSession) Delete(key string) error {
+	s.mx.Lock()
+	delete(s.values, key)
+	s.mx.Unlock()
+	return nil
+}
+
+func (s *MemSession) SessionID() string {
+	return s.id
+}
+
+func (s *MemSession) Flush() error {
+	return nil
+}
+
+func (s *MemSession) TimeCreated() time.Time {
+	return s.timeCreated
+}
+
+func (s *MemSession) TimeAccessed() time.Time {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.timeAccessed
+}
+
+var _ session.Session = (*MemSession)(nil)
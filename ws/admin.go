@@ -0,0 +1,299 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/dronm/gobizapp/cluster"
+	"github.com/dronm/gobizapp/logger"
+	"github.com/dronm/gobizapp/services"
+)
+
+var _ services.WSAdminHandler = (*WSServer)(nil)
+
+const clusterSessionsWait = time.Duration(1) * time.Second
+
+// sessionDestroyer is implemented by session managers able to destroy sessions.
+type sessionDestroyer interface {
+	SessionDestroy(sid string) error
+}
+
+// Sessions returns connected sessions with their connections.
+// With a cluster other nodes are asked for their sessions, replies
+// are awaited for one second or until ctx is done.
+func (s *WSServer) Sessions(ctx context.Context) []services.WSSessionInfo {
+	list := s.localSessions()
+	if s.cluster == nil {
+		return list
+	}
+
+	reqID := cluster.NewNodeID()
+	replies := make(chan []services.WSSessionInfo, 16)
+	s.sessReqMx.Lock()
+	s.sessReqs[reqID] = replies
+	s.sessReqMx.Unlock()
+	defer func() {
+		s.sessReqMx.Lock()
+		delete(s.sessReqs, reqID)
+		s.sessReqMx.Unlock()
+	}()
+
+	s.publishToCluster(&cluster.Message{Kind: cluster.KindSessions, RequestID: reqID})
+
+	timer := time.NewTimer(clusterSessionsWait)
+	defer timer.Stop()
+	for {
+		select {
+		case nodeList := <-replies:
+			list = append(list, nodeList...)
+		case <-timer.C:
+			return mergeSessions(list)
+		case <-ctx.Done():
+			return mergeSessions(list)
+		}
+	}
+}
+
+// localSessions returns sessions connected to this node.
+func (s *WSServer) localSessions() []services.WSSessionInfo {
+	s.clientsMx.RLock()
+	defer s.clientsMx.RUnlock()
+
+	list := make([]services.WSSessionInfo, 0, len(s.clients))
+	for sessionID, clientList := range s.clients {
+		info := services.WSSessionInfo{
+			SessionID:   sessionID,
+			ClientCount: len(clientList),
+			Clients:     make([]services.WSConnInfo, 0, len(clientList)),
+		}
+		if len(clientList) > 0 {
+			info.Role = s.clientRole(clientList[0])
+		}
+		for _, c := range clientList {
+			connInfo := c.info()
+			connInfo.NodeID = s.nodeID
+			info.Clients = append(info.Clients, connInfo)
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SessionID < list[j].SessionID })
+
+	return list
+}
+
+// mergeSessions joins connections of the same session from different nodes.
+func mergeSessions(list []services.WSSessionInfo) []services.WSSessionInfo {
+	byID := make(map[string]int, len(list))
+	merged := make([]services.WSSessionInfo, 0, len(list))
+	for _, info := range list {
+		i, ok := byID[info.SessionID]
+		if !ok {
+			byID[info.SessionID] = len(merged)
+			merged = append(merged, info)
+			continue
+		}
+		if merged[i].Role == "" {
+			merged[i].Role = info.Role
+		}
+		merged[i].ClientCount += info.ClientCount
+		merged[i].Clients = append(merged[i].Clients, info.Clients...)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].SessionID < merged[j].SessionID })
+
+	return merged
+}
+
+// replySessions sends sessions of this node to the requesting node.
+func (s *WSServer) replySessions(req *cluster.Message) {
+	payload, err := json.Marshal(s.localSessions())
+	if err != nil {
+		logger.Logger.Errorf("WSServer replySessions json.Marshal(): %v", err)
+		return
+	}
+	s.publishToCluster(&cluster.Message{
+		Kind:      cluster.KindSessionsReply,
+		RequestID: req.RequestID,
+		ToNodeID:  req.NodeID,
+		Payload:   payload,
+	})
+}
+
+// onSessionsReply passes sessions of another node to the waiting request.
+func (s *WSServer) onSessionsReply(msg *cluster.Message) {
+	if msg.ToNodeID != s.nodeID {
+		return
+	}
+	s.sessReqMx.Lock()
+	replies, ok := s.sessReqs[msg.RequestID]
+	s.sessReqMx.Unlock()
+	if !ok {
+		return // request has timed out
+	}
+	var list []services.WSSessionInfo
+	if err := json.Unmarshal(msg.Payload, &list); err != nil {
+		logger.Logger.Errorf("WSServer onSessionsReply json.Unmarshal(): %v", err)
+		return
+	}
+	select {
+	case replies <- list:
+	default:
+		logger.Logger.Errorf("WSServer onSessionsReply: reply of node %s dropped", msg.NodeID)
+	}
+}
+
+// DisconnectClient closes the connection of the session with the given ID.
+// With a cluster the connection is also closed on the node it belongs to.
+func (s *WSServer) DisconnectClient(sessionID, connID string) error {
+	found := s.disconnectLocal(sessionID, connID)
+	if s.cluster != nil {
+		if !found {
+			s.publishToCluster(&cluster.Message{Kind: cluster.KindDisconnect, ClientID: sessionID, ConnID: connID})
+		}
+		return nil
+	}
+	if !found {
+		return fmt.Errorf("WSServer.DisconnectClient() connection not found: %s", connID)
+	}
+	return nil
+}
+
+// DisconnectSession closes all connections of the session on all nodes.
+func (s *WSServer) DisconnectSession(sessionID string) error {
+	found := s.disconnectLocal(sessionID, "")
+	if s.cluster != nil {
+		s.publishToCluster(&cluster.Message{Kind: cluster.KindDisconnect, ClientID: sessionID})
+		return nil
+	}
+	if !found {
+		return fmt.Errorf("WSServer.DisconnectSession() session not found: %s", sessionID)
+	}
+	return nil
+}
+
+// LogoutSession destroys the session and closes all its connections,
+// so the client can not reconnect with it.
+func (s *WSServer) LogoutSession(sessionID string) error {
+	destroyer, ok := s.sessManager.(sessionDestroyer)
+	if !ok {
+		return fmt.Errorf("WSServer.LogoutSession() session manager can not destroy sessions")
+	}
+	if err := destroyer.SessionDestroy(sessionID); err != nil {
+		return fmt.Errorf("SessionDestroy(): %v", err)
+	}
+	s.disconnectLocal(sessionID, "")
+	if s.cluster != nil {
+		s.publishToCluster(&cluster.Message{Kind: cluster.KindDisconnect, ClientID: sessionID})
+	}
+	return nil
+}
+
+// disconnectLocal closes connections of the session on this node,
+// all of them if connID is empty. It returns false if nothing was found.
+func (s *WSServer) disconnectLocal(sessionID, connID string) bool {
+	if connID != "" {
+		client := s.clientByConnID(sessionID, connID)
+		if client == nil {
+			return false
+		}
+		s.disconnect(client)
+		return true
+	}
+
+	s.clientsMx.RLock()
+	targets := append([]*Client(nil), s.clients[sessionID]...)
+	s.clientsMx.RUnlock()
+
+	for _, c := range targets {
+		s.disconnect(c)
+	}
+	return len(targets) > 0
+}
+
+func (s *WSServer) disconnect(c *Client) {
+	logger.Logger.Warnf("WSServer disconnecting %s of session %s", c.ConnID, c.ID)
+	if t, ok := c.Transport.(*wsTransport); ok {
+		t.closeWithCode(websocket.CloseNormalClosure, "disconnected by administrator")
+	}
+	s.removeConn(c.ID, c)
+}
+
+// Broadcast sends the event to all connections regardless of subscriptions,
+// or only to connections of the role if it is not empty.
+// It returns the number of connections of this node the event was sent to,
+// other nodes of the cluster deliver the event in background.
+func (s *WSServer) Broadcast(eventID string, payload any, role string) (int, error) {
+	cnt, err := s.broadcastLocal(eventID, payload, role)
+	if err != nil {
+		return cnt, err
+	}
+	if s.cluster != nil {
+		payloadB, err := json.Marshal(payload)
+		if err != nil {
+			return cnt, fmt.Errorf("json.Marshal(): %v", err)
+		}
+		s.publishToCluster(&cluster.Message{Kind: cluster.KindBroadcast, EventID: eventID, Role: role, Payload: payloadB})
+	}
+	return cnt, nil
+}
+
+// broadcastLocal sends the event to connections of this node.
+func (s *WSServer) broadcastLocal(eventID string, payload any, role string) (int, error) {
+	msg := SrvResponse{EventID: eventID, Payload: payload}
+
+	s.clientsMx.RLock()
+	var targets []*Client
+	for _, clientList := range s.clients {
+		for _, c := range clientList {
+			if role == "" || s.clientRole(c) == role {
+				targets = append(targets, c)
+			}
+		}
+	}
+	s.clientsMx.RUnlock()
+
+	encoded := make(map[string][]byte) // message by codec name
+	var cnt int
+	for _, c := range targets {
+		data, ok := encoded[c.Codec.Name()]
+		if !ok {
+			var err error
+			if data, err = c.Codec.Marshal(msg); err != nil {
+				return cnt, fmt.Errorf("Codec.Marshal(): %v", err)
+			}
+			encoded[c.Codec.Name()] = data
+		}
+		if err := c.SendEvent(eventID, data); err != nil {
+			go s.removeConn(c.ID, c)
+			continue
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// info returns client description for the admin service.
+func (c *Client) info() services.WSConnInfo {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	events := make([]string, 0, len(c.events))
+	for ev := range c.events {
+		events = append(events, ev)
+	}
+	sort.Strings(events)
+
+	return services.WSConnInfo{
+		ConnID:      c.ConnID,
+		Transport:   c.Transport.Name(),
+		RemoteAddr:  c.RemoteAddr,
+		UserAgent:   c.UserAgent,
+		ConnectedAt: c.ConnectedAt,
+		VisitedAt:   c.VisitedAt,
+		Events:      events,
+	}
+}
@@ -0,0 +1,161 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	sess "github.com/dronm/session"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/dronm/gobizapp/controllers"
+)
+
+const (
+	defAuthTimeout  = time.Duration(10) * time.Second
+	TokenQueryParam = "token"
+)
+
+// FuncAuth is the first client message for token authentication:
+// {"f": "auth", "q": "<query ID>", "p": {"token": "<bearer token>"}}.
+const FuncAuth = "auth"
+
+// AuthFunc validates a bearer token and returns the session ID
+// it belongs to, the session is then loaded through SessionManager.
+type AuthFunc = func(token string) (sessionID string, err error)
+
+type authPayload struct {
+	Token string `json:"token"`
+}
+
+// newUpgrader returns an upgrader with origin check and subprotocols
+// consisting of codec names and application subprotocols.
+func (s *WSServer) newUpgrader(wsInit *WSInit) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin:       s.checkOrigin,
+		EnableCompression: wsInit.EnableCompression,
+		Subprotocols:      append(codecSubprotocols(), wsInit.Subprotocols...),
+	}
+}
+
+// checkOrigin allows requests without Origin header (not browsers) and
+// origins from the allow list. Without the list only the same host is allowed.
+func (s *WSServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(s.allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+	origin = strings.TrimSuffix(origin, "/")
+	for _, o := range s.allowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// routePermission returns the permission middleware of the route.
+// With token authentication the cookie session has nothing to do with the caller,
+// the permission is checked with checkSessionMethod once the session is known.
+func (s *WSServer) routePermission(method string) gin.HandlerFunc {
+	if s.authenticate == nil {
+		return s.checkPermission(method)
+	}
+	return func(c *gin.Context) {
+		c.Next()
+	}
+}
+
+// checkSessionMethod checks the permission of the authenticated session,
+// method is in Service.Method form.
+func (s *WSServer) checkSessionMethod(userSess sess.Session, method string) error {
+	if s.isMethodAllowed == nil {
+		return nil
+	}
+	return s.isMethodAllowed(userSess, strings.Replace(method, ".", "", 1))
+}
+
+// requestSession returns the session of the request, method is the route permission.
+// With token authentication the token is taken from the query, nil is returned
+// if there is no token, in this case the token is expected in the first message.
+// Otherwise the cookie session is used.
+// On error the response is served and nil returned with false.
+func (s *WSServer) requestSession(c *gin.Context, funcName, method string) (sess.Session, bool) {
+	if s.authenticate == nil {
+		userSess := controllers.GetSession(c, funcName)
+		return userSess, userSess != nil
+	}
+
+	token := c.Query(TokenQueryParam)
+	if token == "" {
+		return nil, true
+	}
+	userSess, err := s.sessionByToken(token)
+	if err != nil {
+		controllers.ServeError(c, http.StatusUnauthorized, funcName+" sessionByToken()", err)
+		return nil, false
+	}
+	if err := s.checkSessionMethod(userSess, method); err != nil {
+		controllers.ServeError(c, http.StatusForbidden, funcName+" checkSessionMethod()", err)
+		return nil, false
+	}
+	return userSess, true
+}
+
+func (s *WSServer) sessionByToken(token string) (sess.Session, error) {
+	sessionID, err := s.authenticate(token)
+	if err != nil {
+		return nil, fmt.Errorf("authenticate(): %v", err)
+	}
+	if sessionID == "" {
+		return nil, fmt.Errorf("authenticate(): empty session ID")
+	}
+	userSess, err := s.sessManager.SessionStart(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("SessionStart(): %v", err)
+	}
+	return userSess, nil
+}
+
+// readAuthMessage waits for FuncAuth message and returns the session
+// with the query ID of the message.
+func (s *WSServer) readAuthMessage(conn *websocket.Conn, codec Codec) (sess.Session, string, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(s.authTimeout)); err != nil {
+		return nil, "", err
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return nil, "", fmt.Errorf("conn.ReadMessage(): %v", err)
+	}
+
+	clientMsg := ClientMessage{}
+	if err := codec.DecodeClientMessage(msg, &clientMsg); err != nil {
+		return nil, "", fmt.Errorf("DecodeClientMessage(): %v", err)
+	}
+	if clientMsg.Func != FuncAuth {
+		return nil, "", fmt.Errorf("expected %s message, got %s", FuncAuth, clientMsg.Func)
+	}
+	var p authPayload
+	if err := json.Unmarshal(clientMsg.Payload, &p); err != nil {
+		return nil, "", fmt.Errorf("json.Unmarshal(): %v", err)
+	}
+	userSess, err := s.sessionByToken(p.Token)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.checkSessionMethod(userSess, "WS.Init"); err != nil {
+		return nil, "", fmt.Errorf("checkSessionMethod(): %v", err)
+	}
+	return userSess, clientMsg.QueryID, nil
+}
@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dronm/gobizapp/api"
+	"github.com/dronm/gobizapp/controllers"
+	"github.com/dronm/gobizapp/database"
+	"github.com/dronm/gobizapp/logger"
+)
+
+const defMaxConcurrentCalls = 8
+
+// FuncCancel is a control message cancelling a call,
+// query ID of the message is the ID of the call to cancel:
+// {"f": "cancel", "q": "<query ID>"}.
+const FuncCancel = "cancel"
+
+// connCalls tracks method calls of one connection.
+// Calls are run concurrently up to the limit, every call has a context
+// derived from the connection context.
+type connCalls struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	mx       sync.Mutex
+	inflight map[string]context.CancelFunc // key is a query ID
+}
+
+func newConnCalls(limit int) *connCalls {
+	if limit <= 0 {
+		limit = defMaxConcurrentCalls
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connCalls{
+		ctx:      ctx,
+		cancelFn: cancel,
+		sem:      make(chan struct{}, limit),
+		inflight: make(map[string]context.CancelFunc),
+	}
+}
+
+var (
+	errTooManyCalls  = errors.New("too many calls")
+	errDuplicateCall = errors.New("duplicate query ID")
+)
+
+// tryStart takes a call slot and returns a context for the call, release must be
+// called when the call is done. errTooManyCalls is returned while the limit is reached
+// or the connection is closing, errDuplicateCall if a call with the same query ID
+// is still running. It never blocks the read loop.
+func (cc *connCalls) tryStart(queryID string, timeout time.Duration) (context.Context, func(), error) {
+	if cc.ctx.Err() != nil {
+		return nil, nil, errTooManyCalls
+	}
+	cc.mx.Lock()
+	if _, ok := cc.inflight[queryID]; ok && queryID != "" {
+		cc.mx.Unlock()
+		return nil, nil, errDuplicateCall
+	}
+	select {
+	case cc.sem <- struct{}{}:
+	default:
+		cc.mx.Unlock()
+		return nil, nil, errTooManyCalls
+	}
+	cc.wg.Add(1)
+	ctx, cancel := context.WithTimeout(cc.ctx, timeout)
+	if queryID != "" {
+		cc.inflight[queryID] = cancel
+	}
+	cc.mx.Unlock()
+
+	return ctx, func() {
+		cancel()
+		if queryID != "" {
+			cc.mx.Lock()
+			delete(cc.inflight, queryID)
+			cc.mx.Unlock()
+		}
+		<-cc.sem
+		cc.wg.Done()
+	}, nil
+}
+
+// cancel cancels the call with the query ID if it is still running.
+func (cc *connCalls) cancel(queryID string) {
+	cc.mx.Lock()
+	cancel, ok := cc.inflight[queryID]
+	cc.mx.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// cancelAll cancels all calls and waits for them to return.
+func (cc *connCalls) cancelAll() {
+	cc.cancelFn()
+	cc.wg.Wait()
+}
+
+// callMethod runs Service.Method in the call context and sends the result to the client.
+// Nothing is sent for calls cancelled by the client or by closing the connection.
+func (s *WSServer) callMethod(ctx context.Context, release func(), client *Client, service, method string, params []string, queryID string) {
+	defer release()
+	defer s.callDone()
+
+	// query ID lets the client match responses as calls finish in any order
+	resp := SrvResponse{EventID: "Response", QueryID: queryID}
+
+	resHTTP, payload, err := controllers.CallServiceMethod(
+		ctx,
+		service,
+		method,
+		params,
+		&api.ServiceContext{
+			DB:      database.DB,
+			Session: client.Session,
+			QueryID: queryID,
+		},
+	)
+
+	if errors.Is(ctx.Err(), context.Canceled) {
+		logger.Logger.Debugf("ws: call %s.%s query %s cancelled", service, method, queryID)
+		return
+	}
+
+	if err != nil {
+		resp.Error = NewSrvResponseError(
+			resHTTP,
+			"controllers.CallServiceMethod",
+			s.IsProduction, err,
+		)
+		_ = s.SendMessage(client, &resp)
+		return
+	}
+
+	if payload != nil {
+		resp.Payload = payload
+		if err := s.SendMessage(client, &resp); err != nil {
+			logger.Logger.Errorf("error sending response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnCallsDuplicateQueryID(t *testing.T) {
+	cc := newConnCalls(2)
+	ctx, release, err := cc.tryStart("q1", time.Minute)
+	if err != nil {
+		t.Fatalf("tryStart(): %v", err)
+	}
+	if _, _, err := cc.tryStart("q1", time.Minute); err != errDuplicateCall {
+		t.Fatalf("expected duplicate call error, got %v", err)
+	}
+
+	cc.cancel("q1")
+	if ctx.Err() == nil {
+		t.Error("call is not cancelled")
+	}
+	release()
+
+	// the ID is free again after the call is done
+	_, release, err = cc.tryStart("q1", time.Minute)
+	if err != nil {
+		t.Fatalf("tryStart() after release: %v", err)
+	}
+	release()
+	cc.cancelAll()
+}
@@ -1,27 +1,167 @@
 package ws
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
 	"sync"
 	"time"
 
+	sess "github.com/dronm/session"
 	"github.com/gorilla/websocket"
 )
 
 // Client holds information about client connections.
 // Client events are stored in events map where key is an event ID.
+// ID is a session ID, one session can have many connections,
+// every connection has its own unique ConnID.
 type Client struct {
 	ID          string
-	Conn        *websocket.Conn
+	ConnID      string
+	Conn        *websocket.Conn // nil for sse and polling clients
+	Transport   Transport
 	EventServer EventPubSub
+	Session     sess.Session // session the connection was opened with
+	Codec       Codec        // message encoding negotiated at connect time
+	UserID      string // resolved on connect, empty if unknown
+	Role        string // resolved on connect
+	RemoteAddr  string
+	UserAgent   string
+	ConnectedAt time.Time
 
-	writeMu   sync.Mutex
 	mx        sync.Mutex	// events & visited
 	VisitedAt time.Time
 	events    map[string]struct{}
+
+	queue *sendQueue // nil until started, messages are written directly
 }
 
+// NewClient returns a websocket client without a user session.
 func NewClient(id string, conn *websocket.Conn, evSrv EventPubSub) *Client {
-	return &Client{ID: id, Conn: conn, events: make(map[string]struct{}), EventServer: evSrv}
+	return NewSessionClient(id, conn, evSrv, nil)
+}
+
+// NewSessionClient returns a websocket client bound to the user session.
+func NewSessionClient(id string, conn *websocket.Conn, evSrv EventPubSub, userSess sess.Session) *Client {
+	c := NewTransportClient(id, newWSTransport(conn, defWriteWait), evSrv, userSess)
+	c.Conn = conn
+	return c
+}
+
+// NewTransportClient returns a client with arbitrary transport.
+func NewTransportClient(id string, t Transport, evSrv EventPubSub, userSess sess.Session) *Client {
+	now := time.Now()
+	return &Client{
+		ID:          id,
+		ConnID:      newConnID(),
+		Transport:   t,
+		events:      make(map[string]struct{}),
+		EventServer: evSrv,
+		Session:     userSess,
+		Codec:       jsonCodecInst,
+		VisitedAt:   now,
+		ConnectedAt: now,
+	}
+}
+
+// setRequestInfo saves client address and user agent from the connection request.
+func (c *Client) setRequestInfo(r *http.Request, remoteAddr string) {
+	if remoteAddr == "" {
+		remoteAddr = r.RemoteAddr
+	}
+	c.RemoteAddr = remoteAddr
+	c.UserAgent = r.UserAgent()
+}
+
+func newConnID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Send sends encoded message through client transport.
+func (c *Client) Send(data []byte) error {
+	return c.SendEvent("", data)
+}
+
+// SendEvent puts encoded event message to the client send queue.
+// Without the queue the message is written directly.
+func (c *Client) SendEvent(eventID string, data []byte) error {
+	if c.queue == nil {
+		return c.Transport.Send(data)
+	}
+	return c.queue.push(eventID, data)
+}
+
+// SendJSON sends JSON encoded message re-encoding it with the client codec.
+func (c *Client) SendJSON(eventID string, data []byte) error {
+	encoded, err := c.Codec.FromJSON(data)
+	if err != nil {
+		return err
+	}
+	return c.SendEvent(eventID, encoded)
+}
+
+// startQueue starts the send queue writer, onFail is called once
+// if the transport write fails.
+func (c *Client) startQueue(size int, policy OverflowPolicy, counters *queueCounters, onFail func(error)) {
+	c.queue = newSendQueue(size, policy, counters)
+	go c.queue.run(c.Transport, onFail)
+}
+
+func (c *Client) stopQueue() {
+	if c.queue != nil {
+		c.queue.close()
+	}
+}
+
+// flushQueue waits for queued messages to be written until ctx is done.
+func (c *Client) flushQueue(ctx context.Context) error {
+	if c.queue == nil {
+		return nil
+	}
+	return c.queue.flush(ctx)
+}
+
+// QueueLen returns the number of messages waiting to be sent.
+func (c *Client) QueueLen() int {
+	if c.queue == nil {
+		return 0
+	}
+	return c.queue.len()
+}
+
+// Dropped returns the number of messages dropped due to queue overflow.
+func (c *Client) Dropped() uint64 {
+	if c.queue == nil {
+		return 0
+	}
+	return c.queue.dropped.Load()
+}
+
+// Touch updates client visit time.
+func (c *Client) Touch() {
+	c.mx.Lock()
+	c.VisitedAt = time.Now()
+	c.mx.Unlock()
+}
+
+// Visited returns last client activity time.
+func (c *Client) Visited() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.VisitedAt
+}
+
+// HasEvent returns true if the client is subscribed to the event.
+func (c *Client) HasEvent(ID string) bool {
+	c.mx.Lock()
+	_, ok := c.events[ID]
+	c.mx.Unlock()
+	return ok
 }
 
 // AddEvent registeres a new event to the Client by its ID.
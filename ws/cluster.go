@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"github.com/dronm/gobizapp/cluster"
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	clusterPublishTimeout = time.Duration(5) * time.Second
+	clusterQueueSize      = 1024
+)
+
+// publishToCluster queues the message for other nodes, publishing is done
+// by clusterPublisher so callers are not blocked by the bus.
+// Errors are logged as local delivery has already been done.
+func (s *WSServer) publishToCluster(msg *cluster.Message) {
+	msg.NodeID = s.nodeID
+
+	select {
+	case s.clusterOut <- msg:
+	default:
+		logger.Logger.Errorf("WSServer cluster queue is full, message %s dropped", msg.Kind)
+	}
+}
+
+// clusterPublisher publishes queued messages until clusterStop is closed,
+// then publishes the rest of the queue.
+func (s *WSServer) clusterPublisher() {
+	defer close(s.clusterDone)
+
+	publish := func(msg *cluster.Message) {
+		ctx, cancel := context.WithTimeout(context.Background(), clusterPublishTimeout)
+		defer cancel()
+		if err := s.cluster.Publish(ctx, msg); err != nil {
+			logger.Logger.Errorf("WSServer cluster Publish(): %v", err)
+		}
+	}
+	for {
+		select {
+		case msg := <-s.clusterOut:
+			publish(msg)
+		case <-s.clusterStop:
+			for {
+				select {
+				case msg := <-s.clusterOut:
+					publish(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// onClusterMessage delivers messages from other nodes to local clients.
+func (s *WSServer) onClusterMessage(msg *cluster.Message) {
+	if msg.NodeID == s.nodeID {
+		return // already delivered
+	}
+
+	switch msg.Kind {
+	case cluster.KindEvent:
+		if err := s.PublishLocalEvent(msg.PublisherID, msg.EventID, msg.Payload); err != nil {
+			logger.Logger.Errorf("WSServer onClusterMessage PublishLocalEvent(): %v", err)
+		}
+
+	case cluster.KindDirect:
+		if _, err := s.sendToClientIDLocal(msg.ClientID, msg.Payload); err != nil {
+			logger.Logger.Errorf("WSServer onClusterMessage sendToClientIDLocal(): %v", err)
+		}
+
+	case cluster.KindUser:
+		s.sendToSetLocal(s.users, msg.UserID, msg.Payload)
+
+	case cluster.KindRole:
+		s.sendToSetLocal(s.roles, msg.Role, msg.Payload)
+
+	case cluster.KindBroadcast:
+		if _, err := s.broadcastLocal(msg.EventID, msg.Payload, msg.Role); err != nil {
+			logger.Logger.Errorf("WSServer onClusterMessage broadcastLocal(): %v", err)
+		}
+
+	case cluster.KindDisconnect:
+		s.disconnectLocal(msg.ClientID, msg.ConnID)
+
+	case cluster.KindSessions:
+		s.replySessions(msg)
+
+	case cluster.KindSessionsReply:
+		s.onSessionsReply(msg)
+
+	case cluster.KindPresence:
+		s.onPresence(msg)
+
+	case cluster.KindPresenceSync:
+		s.replyPresence()
+
+	default:
+		logger.Logger.Errorf("WSServer onClusterMessage unknown message kind: %s", msg.Kind)
+	}
+}
@@ -0,0 +1,230 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// Subprotocol names used for codec selection at connect time.
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+)
+
+// Codec encodes server messages and decodes client messages of a connection.
+// The envelope (ClientMessage/SrvResponse) is the same for all codecs,
+// ClientMessage.Payload is always converted to JSON as services expect JSON params.
+type Codec interface {
+	Name() string
+	MessageType() int // websocket frame type
+	Marshal(v any) ([]byte, error)
+	FromJSON(data []byte) ([]byte, error) // re-encodes JSON encoded message
+	DecodeClientMessage(data []byte, msg *ClientMessage) error
+}
+
+var (
+	jsonCodecInst    Codec = jsonCodec{}
+	msgpackCodecInst Codec = newMsgpackCodec()
+)
+
+// codecs are supported codecs in order of preference
+// when the client offers several subprotocols.
+var codecs = []Codec{jsonCodecInst, msgpackCodecInst}
+
+// codecByName returns a codec for the negotiated subprotocol, JSON by default.
+func codecByName(name string) Codec {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return jsonCodecInst
+}
+
+func codecSubprotocols() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) FromJSON(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (jsonCodec) DecodeClientMessage(data []byte, msg *ClientMessage) error {
+	return json.Unmarshal(data, msg)
+}
+
+// msgpackCodec is a binary MessagePack codec.
+// Values are marshaled to JSON first, so json tags and custom
+// json marshalers of models are respected.
+type msgpackCodec struct {
+	h *codec.MsgpackHandle
+}
+
+func newMsgpackCodec() *msgpackCodec {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	return &msgpackCodec{h: h}
+}
+
+func (*msgpackCodec) Name() string {
+	return CodecMsgpack
+}
+
+func (*msgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (c *msgpackCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.FromJSON(data)
+}
+
+func (c *msgpackCodec) FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("json Decode(): %v", err)
+	}
+
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, c.h).Encode(jsonNumbers(v)); err != nil {
+		return nil, fmt.Errorf("msgpack Encode(): %v", err)
+	}
+	return out, nil
+}
+
+// DecodeClientMessage decodes the message, payload map is converted to a JSON
+// object keeping the order of keys as service params are positional.
+func (c *msgpackCodec) DecodeClientMessage(data []byte, msg *ClientMessage) error {
+	var env struct {
+		Func    string    `codec:"f"`
+		QueryID string    `codec:"q"`
+		Payload codec.Raw `codec:"p"`
+	}
+	if err := codec.NewDecoderBytes(data, c.h).Decode(&env); err != nil {
+		return fmt.Errorf("msgpack Decode(): %v", err)
+	}
+	msg.Func = env.Func
+	msg.QueryID = env.QueryID
+	msg.Payload = nil
+	if len(env.Payload) == 0 {
+		return nil
+	}
+
+	payload, err := c.payloadToJSON(env.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Payload = payload
+	return nil
+}
+
+func (c *msgpackCodec) payloadToJSON(raw []byte) (json.RawMessage, error) {
+	n, hdr, ok := msgpackMapLen(raw)
+	if !ok {
+		// not a map, let params decoder report an error
+		var v any
+		if err := codec.NewDecoderBytes(raw, c.h).Decode(&v); err != nil {
+			return nil, fmt.Errorf("msgpack Decode(): %v", err)
+		}
+		return json.Marshal(v)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	dec := codec.NewDecoderBytes(raw[hdr:], c.h)
+	for i := 0; i < n; i++ {
+		var key string
+		var val any
+		if err := dec.Decode(&key); err != nil {
+			return nil, fmt.Errorf("msgpack Decode() key: %v", err)
+		}
+		if err := dec.Decode(&val); err != nil {
+			return nil, fmt.Errorf("msgpack Decode() value: %v", err)
+		}
+		keyB, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		valB, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("json.Marshal() %s: %v", key, err)
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(keyB)
+		buf.WriteByte(':')
+		buf.Write(valB)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// msgpackMapLen returns map length and header size if raw is a msgpack map.
+func msgpackMapLen(raw []byte) (int, int, bool) {
+	if len(raw) == 0 {
+		return 0, 0, false
+	}
+	b := raw[0]
+	switch {
+	case b >= 0x80 && b <= 0x8f: // fixmap
+		return int(b & 0x0f), 1, true
+	case b == 0xde && len(raw) >= 3: // map 16
+		return int(raw[1])<<8 | int(raw[2]), 3, true
+	case b == 0xdf && len(raw) >= 5: // map 32
+		return int(raw[1])<<24 | int(raw[2])<<16 | int(raw[3])<<8 | int(raw[4]), 5, true
+	}
+	return 0, 0, false
+}
+
+// jsonNumbers replaces json.Number values with integers where possible.
+func jsonNumbers(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]any:
+		for k, item := range val {
+			val[k] = jsonNumbers(item)
+		}
+	case []any:
+		for i, item := range val {
+			val[i] = jsonNumbers(item)
+		}
+	}
+	return v
+}
@@ -0,0 +1,137 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/dronm/gobizapp/controllers"
+	"github.com/dronm/gobizapp/errs"
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	defDrainTimeout   = time.Duration(10) * time.Second
+	defReconnectDelay = time.Duration(2) * time.Second
+	drainPollInterval = time.Duration(50) * time.Millisecond
+	closeFrameTimeout = time.Duration(2) * time.Second
+)
+
+// IsDraining returns true if the server is shutting down
+// and does not accept new connections and calls.
+func (s *WSServer) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// rejectDraining responds with 503 and Retry-After header if the server is draining.
+func (s *WSServer) rejectDraining(c *gin.Context, funcName string) bool {
+	if !s.IsDraining() {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(s.reconnectDelay.Seconds()+1)))
+	controllers.ServeError(c, http.StatusServiceUnavailable, funcName, errs.NewPublicError(errs.ServerRestarting))
+	return true
+}
+
+// callStarted and callDone count in-flight calls of all connections.
+func (s *WSServer) callStarted() {
+	s.inflight.Add(1)
+}
+
+func (s *WSServer) callDone() {
+	s.inflight.Add(-1)
+}
+
+// waitCalls waits for in-flight calls to finish until ctx is done.
+func (s *WSServer) waitCalls(ctx context.Context) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for s.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			logger.Logger.Warnf("WSServer drain: %d calls still running", s.inflight.Load())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryAfter returns reconnect delay with jitter, so clients do not
+// reconnect all at the same moment.
+func (s *WSServer) retryAfter() time.Duration {
+	return s.reconnectDelay + rand.N(s.reconnectDelay)
+}
+
+// drain stops accepting new connections and calls, waits for in-flight calls
+// up to the drain timeout and closes all connections in parallel with
+// a reconnect hint.
+func (s *WSServer) drain(ctx context.Context) {
+	s.draining.Store(true)
+
+	waitCtx, cancel := context.WithTimeout(ctx, s.drainTimeout)
+	s.waitCalls(waitCtx)
+	cancel()
+
+	s.ShutdownWebSockets(ctx)
+}
+
+// ShutdownWebSockets flushes send queues and sends close frames to all connections in parallel.
+// Websocket clients get CloseServiceRestart code with
+// "server restarting, retry after N ms" reason, sse clients get the retry field.
+func (s *WSServer) ShutdownWebSockets(ctx context.Context) {
+	s.clientsMx.RLock()
+	var targets []*Client
+	for _, clientList := range s.clients {
+		targets = append(targets, clientList...)
+	}
+	s.clientsMx.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, c := range targets {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+
+			// responses of finished calls are written before the close frame
+			flushCtx, cancel := context.WithTimeout(ctx, s.writeWait)
+			if err := c.flushQueue(flushCtx); err != nil {
+				logger.Logger.Warnf("WSServer flushing %s on shutdown: %v", c.ConnID, err)
+			}
+			cancel()
+
+			retry := s.retryAfter().Milliseconds()
+			switch t := c.Transport.(type) {
+			case *wsTransport:
+				reason := fmt.Sprintf("server restarting, retry after %d ms", retry)
+				if err := t.closeWithCode(websocket.CloseServiceRestart, reason); err != nil {
+					logger.Logger.Debugf("WSServer closing %s on shutdown: %v", c.ConnID, err)
+				}
+			case *sseTransport:
+				_ = t.retry(retry)
+			}
+			s.removeConn(c.ID, c)
+		}(c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Logger.Warn("WSServer shutdown: not all connections closed")
+	case <-time.After(closeFrameTimeout + 2*s.writeWait):
+		logger.Logger.Warn("WSServer shutdown: close frames timeout")
+	}
+}
@@ -1,16 +1,17 @@
 package ws
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/dronm/gobizapp/api"
 	"github.com/dronm/gobizapp/controllers"
-	"github.com/dronm/gobizapp/database"
+	"github.com/dronm/gobizapp/errs"
 
 	"github.com/dronm/gobizapp/logger"
 	"github.com/dronm/session"
@@ -19,18 +20,22 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins
-	},
-}
-
 // Init provides an access to a websocket server, it upgrades
 // http to a websocket, mantains a constant connection.
 func (s *WSServer) Init(c *gin.Context) {
 	funcName := "WebSocketInit"
-	sess := controllers.GetSession(c, funcName)
-	if sess == nil {
+	if s.rejectDraining(c, funcName) {
+		return
+	}
+	sess, ok := s.requestSession(c, funcName, "WS.Init")
+	if !ok {
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) && s.sseURL != "" {
+		// hint for clients behind proxies not supporting websockets
+		c.Header("X-Event-SSE", s.sseURL)
+		c.Header("X-Event-Poll", s.pollURL)
+		controllers.ServeError(c, http.StatusUpgradeRequired, funcName, fmt.Errorf("websocket upgrade expected, use sse or long polling"))
 		return
 	}
 	if respCode, err := s.HandleConnection(c.Writer, c.Request, sess, c); err != nil {
@@ -45,47 +50,96 @@ type ClientMessage struct {
 }
 
 func (s *WSServer) HandleConnection(w http.ResponseWriter, r *http.Request, sess session.Session, c *gin.Context) (int, error) {
-    conn, err := upgrader.Upgrade(w, r, nil)
+    conn, err := s.upgrader.Upgrade(w, r, nil)
     if err != nil {
         return http.StatusInternalServerError, fmt.Errorf("upgrader.Upgrade(): %v", err)
     }
+    if s.upgrader.EnableCompression {
+        // no effect if the client has not negotiated permessage-deflate
+        conn.EnableWriteCompression(true)
+        if s.compressionLevel != 0 {
+            if err := conn.SetCompressionLevel(s.compressionLevel); err != nil {
+                logger.Logger.Warnf("ws: SetCompressionLevel(): %v", err)
+            }
+        }
+    }
+    if s.maxMessageSize > 0 {
+        conn.SetReadLimit(s.maxMessageSize)
+    }
+    // empty or application subprotocol means JSON
+    codec := codecByName(conn.Subprotocol())
+
+    var authQueryID string
+    if sess == nil {
+        // token authentication with the first message
+        if sess, authQueryID, err = s.readAuthMessage(conn, codec); err != nil {
+            conn.WriteControl(websocket.CloseMessage,
+                websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "authentication failed"),
+                time.Now().Add(s.writeWait),
+            )
+            conn.Close()
+            return http.StatusUnauthorized, fmt.Errorf("readAuthMessage(): %v", err)
+        }
+    }
 
     clientID := sess.SessionID()
     logger.Logger.Warnf("WSServer HandleConnection: adding new client with ID: %s", clientID)
 
-    client := NewClient(clientID, conn, s.EventServer)
+    transport := newWSTransport(conn, s.writeWait)
+    transport.msgType = codec.MessageType()
+    client := NewTransportClient(clientID, transport, s.EventServer, sess)
+    client.Conn = conn
+    client.Codec = codec
+    var remoteAddr string
+    if c != nil {
+        remoteAddr = c.ClientIP()
+    }
+    client.setRequestInfo(r, remoteAddr)
+    s.addClient(client)
 
-    s.clientsMx.Lock()
-    s.clients[clientID] = append(s.clients[clientID], client)
-    s.clientsMx.Unlock()
+    // dead peer detection: any frame from the client including pong
+    // extends read deadline, pings are sent every pingInterval
+    conn.SetReadDeadline(time.Now().Add(s.pongWait))
+    conn.SetPongHandler(func(string) error {
+        return conn.SetReadDeadline(time.Now().Add(s.pongWait))
+    })
 
     defer func() {
         logger.Logger.Warnf("ws: closing connection %s, removing from session client list", clientID)
+        s.removeConn(clientID, client)
+    }()
 
-        s.clientsMx.Lock()
-        clients := s.clients[clientID]
-        for i, c := range clients {
-            if c == client {
-                c.RemoveAllEvents()
-                s.clients[clientID] = append(clients[:i], clients[i+1:]...)
-                break
-            }
-        }
-
-        if len(s.clients[clientID]) == 0 {
-            delete(s.clients, clientID)
-        }
-        s.clientsMx.Unlock()
+    if authQueryID != "" {
+        _ = s.SendMessage(client, &SrvResponse{EventID: "Response", QueryID: authQueryID, Payload: true})
+    }
 
-        conn.Close()
-    }()
+    // all in-flight calls are cancelled and awaited before the client is removed
+    calls := newConnCalls(s.maxConcurrentCalls)
+    defer calls.cancelAll()
 
     done := make(chan struct{})
+    readDone := make(chan struct{})
+    defer close(readDone)
     go func() {
-        <-r.Context().Done()
-        logger.Logger.Info("ws: request context closed, terminating connection")
-        conn.Close()
-        close(done)
+        ticker := time.NewTicker(s.pingInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-readDone:
+                return
+            case <-r.Context().Done():
+                logger.Logger.Info("ws: request context closed, terminating connection")
+                conn.Close()
+                close(done)
+                return
+            case <-ticker.C:
+                if err := transport.ping(); err != nil {
+                    logger.Logger.Warnf("ws: ping failed for %s: %v, terminating connection", clientID, err)
+                    conn.Close()
+                    return
+                }
+            }
+        }
     }()
 
     for {
@@ -102,29 +156,49 @@ func (s *WSServer) HandleConnection(w http.ResponseWriter, r *http.Request, sess
                 ) {
                     return http.StatusOK, nil
                 }
+                if errors.Is(err, net.ErrClosed) {
+                    // closed by the server: shutdown, admin, dead peer
+                    return http.StatusOK, nil
+                }
+                var netErr net.Error
+                if errors.As(err, &netErr) && netErr.Timeout() {
+                    logger.Logger.Warnf("ws: no frames from %s within %v, dead peer", clientID, s.pongWait)
+                    return http.StatusOK, nil
+                }
                 return http.StatusInternalServerError, fmt.Errorf("conn.ReadMessage(): %v", err)
             }
 
             logger.Logger.Debugf("Received: type:%d, msg:%s\n", msgType, string(msg))
 
             // update client visit time
-            client.mx.Lock()
-            client.VisitedAt = time.Now()
-            client.mx.Unlock()
+            client.Touch()
+            conn.SetReadDeadline(time.Now().Add(s.pongWait))
 
             resp := SrvResponse{EventID: "Response"}
 
             clientMsg := ClientMessage{}
-            if err := json.Unmarshal(msg, &clientMsg); err != nil {
+            if err := codec.DecodeClientMessage(msg, &clientMsg); err != nil {
                 resp.Error = NewSrvResponseError(
                     http.StatusInternalServerError,
-                    "json.Unmarshal client message",
+                    "Codec.DecodeClientMessage client message",
                     s.IsProduction, err,
                 )
                 _ = s.SendMessage(client, &resp)
                 continue
             }
 
+            if clientMsg.Func == FuncReply {
+                if err := s.onClientReply(client, &clientMsg); err != nil {
+                    logger.Logger.Warnf("ws: client %s reply: %v", client.ConnID, err)
+                }
+                continue
+            }
+
+            if clientMsg.Func == FuncCancel {
+                calls.cancel(clientMsg.QueryID)
+                continue
+            }
+
             if clientMsg.Func == "" {
                 resp.Error = NewSrvResponseError(
                     http.StatusInternalServerError,
@@ -161,52 +235,50 @@ func (s *WSServer) HandleConnection(w http.ResponseWriter, r *http.Request, sess
             // permission check
             if s.isMethodAllowed != nil {
                 if err := s.isMethodAllowed(sess, service[0]+service[1]); err != nil {
-                    conn.WriteMessage(
-                        websocket.CloseMessage,
-                        websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "method is not allowed"),
-                    )
+                    transport.closeWithCode(websocket.ClosePolicyViolation, "method is not allowed")
                     return http.StatusUnauthorized,
                         fmt.Errorf("isMethodAllowed(%s.%s)", service[0], service[1])
                 }
             }
 
-            methDuration := defMaxMethodCallDuration
-            if s.MaxMethodCallDuration != 0 {
-                methDuration = s.MaxMethodCallDuration
-            }
-            ctx, cancel := context.WithTimeout(context.Background(), methDuration)
-
-            var resHTTP int
-            resHTTP, resp.Payload, err = controllers.CallServiceMethod(
-                ctx,
-                service[0],
-                service[1],
-                params,
-                &api.ServiceContext{
-                    DB:      database.DB,
-                    Session: sess,
-                    QueryID: clientMsg.QueryID,
-                },
-            )
-
-            if err != nil {
+            if s.IsDraining() {
+                resp.QueryID = clientMsg.QueryID
                 resp.Error = NewSrvResponseError(
-                    resHTTP,
-                    "controllers.CallServiceMethod",
-                    s.IsProduction, err,
+                    http.StatusServiceUnavailable,
+                    "server is draining",
+                    s.IsProduction, errs.NewPublicError(errs.ServerRestarting),
                 )
                 _ = s.SendMessage(client, &resp)
-                cancel()
                 continue
             }
 
-            if resp.Payload != nil {
-                if err := s.SendMessage(client, &resp); err != nil {
-                    logger.Logger.Errorf("error sending response: %v", err)
+            // over the limit and duplicate calls are refused, reading goes on
+            // so pongs, cancels and other messages are processed
+            methDuration := defMaxMethodCallDuration
+            if s.MaxMethodCallDuration != 0 {
+                methDuration = s.MaxMethodCallDuration
+            }
+            ctx, release, err := calls.tryStart(clientMsg.QueryID, methDuration)
+            if err != nil {
+                resp.QueryID = clientMsg.QueryID
+                if errors.Is(err, errDuplicateCall) {
+                    resp.Error = NewSrvResponseError(
+                        http.StatusConflict,
+                        "duplicate call",
+                        s.IsProduction, errs.NewPublicError(errs.DuplicateCall),
+                    )
+                } else {
+                    resp.Error = NewSrvResponseError(
+                        http.StatusTooManyRequests,
+                        "too many calls",
+                        s.IsProduction, errs.NewPublicError(errs.TooManyCalls),
+                    )
                 }
+                _ = s.SendMessage(client, &resp)
+                continue
             }
-
-            cancel()
+            s.callStarted()
+            go s.callMethod(ctx, release, client, service[0], service[1], params, clientMsg.QueryID)
         }
     }
 }
@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"path"
+	"sync"
+
+	sess "github.com/dronm/session"
+
+	"github.com/dronm/gobizapp/errs"
+)
+
+// These functions are used by event policies.
+// EventAllowFunc is an additional subscription check, a non nil error denies subscription.
+// EventFilterFunc is called for every delivery, it returns the payload to send
+// (original, redacted or filtered) and false if nothing should be sent to this session.
+// The filter always receives the payload as json.RawMessage, on every cluster node
+// and whatever type the publisher passed, so it is decoded the same way everywhere.
+type (
+	EventAllowFunc  = func(userSess sess.Session, eventID string) error
+	EventFilterFunc = func(userSess sess.Session, eventID string, payload any) (any, bool)
+)
+
+// EventPolicy describes who can subscribe to an event
+// and what every subscriber receives.
+type EventPolicy struct {
+	Roles  []string        // roles allowed to subscribe, empty list allows any role
+	Allow  EventAllowFunc  // optional
+	Filter EventFilterFunc // optional
+}
+
+func (p *EventPolicy) roleAllowed(role string) bool {
+	if len(p.Roles) == 0 {
+		return true
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type eventPolicyPattern struct {
+	pattern string
+	policy  *EventPolicy
+}
+
+// EventPolicyRegistry holds subscription policies keyed by event ID or
+// by pattern in path.Match syntax, e.g. "MainMenu.*".
+// Exact IDs take precedence over patterns, patterns are matched
+// in order of registration.
+// Events without a policy are allowed for everyone.
+type EventPolicyRegistry struct {
+	mx       sync.RWMutex
+	exact    map[string]*EventPolicy
+	patterns []eventPolicyPattern
+}
+
+func NewEventPolicyRegistry() *EventPolicyRegistry {
+	return &EventPolicyRegistry{exact: make(map[string]*EventPolicy)}
+}
+
+// Register adds a policy for the given event ID or pattern.
+// Registering the same ID twice replaces the policy.
+func (r *EventPolicyRegistry) Register(eventID string, policy EventPolicy) error {
+	isPattern := hasMeta(eventID)
+	if isPattern {
+		if _, err := path.Match(eventID, ""); err != nil {
+			return err
+		}
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if !isPattern {
+		r.exact[eventID] = &policy
+		return nil
+	}
+	for i, p := range r.patterns {
+		if p.pattern == eventID {
+			r.patterns[i].policy = &policy
+			return nil
+		}
+	}
+	r.patterns = append(r.patterns, eventPolicyPattern{pattern: eventID, policy: &policy})
+
+	return nil
+}
+
+// Lookup returns a policy for the event ID if any.
+func (r *EventPolicyRegistry) Lookup(eventID string) *EventPolicy {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	if p, ok := r.exact[eventID]; ok {
+		return p
+	}
+	for _, p := range r.patterns {
+		if ok, _ := path.Match(p.pattern, eventID); ok {
+			return p.policy
+		}
+	}
+	return nil
+}
+
+// CanSubscribe checks if the session with the given role can subscribe to the event.
+func (r *EventPolicyRegistry) CanSubscribe(userSess sess.Session, role, eventID string) error {
+	p := r.Lookup(eventID)
+	if p == nil {
+		return nil
+	}
+	return p.CanSubscribe(userSess, role, eventID)
+}
+
+// CanSubscribe checks role and calls Allow function if any.
+func (p *EventPolicy) CanSubscribe(userSess sess.Session, role, eventID string) error {
+	if !p.roleAllowed(role) {
+		return errs.NewPublicError(errs.NotAllowed)
+	}
+	if p.Allow != nil {
+		return p.Allow(userSess, eventID)
+	}
+	return nil
+}
+
+// Deliver returns the payload for the given session at delivery time.
+// The second value is false if the event should not be sent to the session at all.
+// Subscription rules are checked again as the session role might have been changed
+// after subscription.
+func (p *EventPolicy) Deliver(userSess sess.Session, role, eventID string, payload any) (any, bool) {
+	if p.CanSubscribe(userSess, role, eventID) != nil {
+		return nil, false
+	}
+	if p.Filter == nil {
+		return payload, true
+	}
+	return p.Filter(userSess, eventID, payload)
+}
+
+func hasMeta(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dronm/gobizapp/controllers"
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	defPollTimeout   = time.Duration(25) * time.Second
+	defPollClientTTL = time.Duration(1) * time.Minute
+	defPollQueueLen  = 1000
+)
+
+// pollTransport keeps messages until the client takes them with the next poll request.
+// If the queue is full, the oldest messages are dropped.
+type pollTransport struct {
+	mx     sync.Mutex
+	queue  []json.RawMessage
+	notify chan struct{} // signals new messages
+	closed bool
+	ttl    *time.Timer // removes client if not polled
+}
+
+func newPollTransport() *pollTransport {
+	return &pollTransport{notify: make(chan struct{}, 1)}
+}
+
+func (t *pollTransport) Name() string {
+	return TransportPoll
+}
+
+func (t *pollTransport) Send(data []byte) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.closed {
+		return fmt.Errorf("poll transport is closed")
+	}
+	if len(t.queue) >= defPollQueueLen {
+		t.queue = t.queue[1:]
+	}
+	t.queue = append(t.queue, append(json.RawMessage(nil), data...))
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take returns all queued messages.
+func (t *pollTransport) take() []json.RawMessage {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	q := t.queue
+	t.queue = nil
+	return q
+}
+
+func (t *pollTransport) Close() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		if t.ttl != nil {
+			t.ttl.Stop()
+		}
+		close(t.notify)
+	}
+	return nil
+}
+
+// PollResponse is returned by the long polling endpoint.
+type PollResponse struct {
+	ConnID   string            `json:"conn_id"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// Poll is a long polling endpoint for clients which can use neither websockets nor sse.
+// The first request without conn_id query parameter registers a new connection and
+// returns its ID immediately. Next requests with this conn_id wait for messages
+// up to the poll timeout. Connection is removed if it is not polled within its TTL.
+// Subscription is done with EventService over regular http API with the same session.
+func (s *WSServer) Poll(c *gin.Context) {
+	funcName := "WSServer.Poll"
+	if s.rejectDraining(c, funcName) {
+		return
+	}
+	sess, ok := s.requestSession(c, funcName, "WS.Poll")
+	if !ok {
+		return
+	}
+	if sess == nil {
+		controllers.ServeError(c, http.StatusUnauthorized, funcName, fmt.Errorf("%s query parameter expected", TokenQueryParam))
+		return
+	}
+
+	connID := c.Query("conn_id")
+	if connID == "" {
+		t := newPollTransport()
+		client := NewTransportClient(sess.SessionID(), t, s.EventServer, sess)
+		client.setRequestInfo(c.Request, c.ClientIP())
+		t.ttl = time.AfterFunc(defPollClientTTL, func() {
+			logger.Logger.Debugf("WSServer Poll: client %s expired", client.ConnID)
+			s.removeConn(client.ID, client)
+		})
+		s.addClient(client)
+
+		logger.Logger.Debugf("WSServer Poll: adding new client with ID: %s", client.ID)
+
+		c.JSON(http.StatusOK, PollResponse{ConnID: client.ConnID, Messages: []json.RawMessage{}})
+		return
+	}
+
+	client := s.clientByConnID(sess.SessionID(), connID)
+	if client == nil {
+		controllers.ServeError(c, http.StatusNotFound, funcName, fmt.Errorf("connection not found: %s", connID))
+		return
+	}
+	t, ok := client.Transport.(*pollTransport)
+	if !ok {
+		controllers.ServeError(c, http.StatusBadRequest, funcName, fmt.Errorf("not a polling connection: %s", connID))
+		return
+	}
+	t.ttl.Reset(defPollClientTTL + defPollTimeout)
+	client.Touch()
+
+	msgs := t.take()
+	if len(msgs) == 0 {
+		select {
+		case <-c.Request.Context().Done():
+		case <-time.After(defPollTimeout):
+		case <-t.notify:
+			msgs = t.take()
+		}
+	}
+	if msgs == nil {
+		msgs = []json.RawMessage{}
+	}
+
+	c.JSON(http.StatusOK, PollResponse{ConnID: client.ConnID, Messages: msgs})
+}
+
+// clientByConnID returns a client of the session by its connection ID.
+func (s *WSServer) clientByConnID(sessionID, connID string) *Client {
+	s.clientsMx.RLock()
+	defer s.clientsMx.RUnlock()
+
+	for _, c := range s.clients[sessionID] {
+		if c.ConnID == connID {
+			return c
+		}
+	}
+	return nil
+}
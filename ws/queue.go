@@ -0,0 +1,221 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defSendQueueSize = 256
+
+// OverflowPolicy defines what happens when client send queue is full.
+type OverflowPolicy int
+
+const (
+	OverflowDropOldest OverflowPolicy = iota // the oldest queued event is dropped
+	OverflowCoalesce                         // a queued event with the same event ID is replaced, otherwise the oldest event is dropped
+	OverflowDisconnect                       // the client is disconnected
+)
+
+// Responses are never dropped, if the full queue holds responses only
+// the client is disconnected whatever the policy is.
+
+var ErrSendQueueOverflow = errors.New("send queue overflow")
+
+// QueueStats holds send queue counters.
+type QueueStats struct {
+	Clients    int    `json:"clients"`
+	QueueDepth int    `json:"queue_depth"`     // messages waiting in all queues
+	MaxDepth   int    `json:"max_queue_depth"` // the longest queue
+	Dropped    uint64 `json:"dropped"`         // messages dropped since start
+	Coalesced  uint64 `json:"coalesced"`       // messages replaced with newer ones since start
+	Overflows  uint64 `json:"overflows"`       // clients disconnected due to overflow since start
+}
+
+// queueCounters are cumulative counters shared by all queues of the server.
+type queueCounters struct {
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+	overflows atomic.Uint64
+}
+
+type queueItem struct {
+	eventID string // empty for responses, they are never coalesced
+	data    []byte
+}
+
+// sendQueue is a bounded client message queue drained by its own writer goroutine,
+// so slow clients do not block publishers.
+type sendQueue struct {
+	mx       sync.Mutex
+	items    []queueItem
+	writing  bool // a batch taken from items is being written
+	size     int
+	policy   OverflowPolicy
+	notify   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Uint64
+	counters *queueCounters
+}
+
+func newSendQueue(size int, policy OverflowPolicy, counters *queueCounters) *sendQueue {
+	if size <= 0 {
+		size = defSendQueueSize
+	}
+	if counters == nil {
+		counters = &queueCounters{}
+	}
+	return &sendQueue{
+		size:     size,
+		policy:   policy,
+		notify:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		counters: counters,
+	}
+}
+
+// push adds a message to the queue applying overflow policy.
+// ErrSendQueueOverflow is returned if the client should be disconnected.
+func (q *sendQueue) push(eventID string, data []byte) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if q.policy == OverflowCoalesce && eventID != "" {
+		for i := range q.items {
+			if q.items[i].eventID == eventID {
+				q.items[i].data = data
+				q.counters.coalesced.Add(1)
+				return nil
+			}
+		}
+	}
+
+	if len(q.items) >= q.size {
+		ind := -1
+		if q.policy != OverflowDisconnect {
+			ind = q.oldestEvent()
+		}
+		if ind < 0 {
+			q.counters.overflows.Add(1)
+			return ErrSendQueueOverflow
+		}
+		copy(q.items[ind:], q.items[ind+1:])
+		q.items[len(q.items)-1] = queueItem{}
+		q.items = q.items[:len(q.items)-1]
+		q.dropped.Add(1)
+		q.counters.dropped.Add(1)
+	}
+	q.items = append(q.items, queueItem{eventID: eventID, data: data})
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// oldestEvent returns the index of the oldest queued event or -1
+// if there are responses only.
+func (q *sendQueue) oldestEvent() int {
+	for i := range q.items {
+		if q.items[i].eventID != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *sendQueue) takeAll() []queueItem {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	items := q.items
+	q.items = nil
+	q.writing = len(items) > 0
+	return items
+}
+
+func (q *sendQueue) written() {
+	q.mx.Lock()
+	q.writing = false
+	q.mx.Unlock()
+}
+
+// flush waits until all queued messages are written, the queue
+// is stopped or ctx is done.
+func (q *sendQueue) flush(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.mx.Lock()
+		empty := len(q.items) == 0 && !q.writing
+		q.mx.Unlock()
+		if empty {
+			return nil
+		}
+		select {
+		case <-q.stop:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *sendQueue) len() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return len(q.items)
+}
+
+func (q *sendQueue) close() {
+	q.stopOnce.Do(func() { close(q.stop) })
+}
+
+// run writes queued messages to the transport until the queue is closed
+// or a write fails, in the latter case onFail is called.
+func (q *sendQueue) run(t Transport, onFail func(error)) {
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.notify:
+		}
+
+		for _, it := range q.takeAll() {
+			if err := t.Send(it.data); err != nil {
+				q.close()
+				onFail(err)
+				return
+			}
+		}
+		q.written()
+	}
+}
+
+// Stats returns send queue statistics of all clients.
+func (s *WSServer) Stats() QueueStats {
+	st := QueueStats{
+		Dropped:   s.queueCounters.dropped.Load(),
+		Coalesced: s.queueCounters.coalesced.Load(),
+		Overflows: s.queueCounters.overflows.Load(),
+	}
+
+	s.clientsMx.RLock()
+	defer s.clientsMx.RUnlock()
+
+	for _, clientList := range s.clients {
+		for _, c := range clientList {
+			st.Clients++
+			depth := c.QueueLen()
+			st.QueueDepth += depth
+			st.MaxDepth = max(st.MaxDepth, depth)
+		}
+	}
+	return st
+}
@@ -0,0 +1,30 @@
+package ws
+
+import "testing"
+
+func TestSendQueueKeepsResponses(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropOldest, OverflowCoalesce} {
+		q := newSendQueue(2, policy, nil)
+		if err := q.push("", []byte("response")); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.push("ev1", []byte("event 1")); err != nil {
+			t.Fatal(err)
+		}
+		// the event is dropped, the response stays
+		if err := q.push("ev2", []byte("event 2")); err != nil {
+			t.Fatalf("policy %d: push(): %v", policy, err)
+		}
+		items := q.takeAll()
+		if len(items) != 2 || string(items[0].data) != "response" || string(items[1].data) != "event 2" {
+			t.Errorf("policy %d: unexpected queue: %q", policy, items)
+		}
+
+		// responses only, the client is disconnected
+		q.push("", []byte("response 1"))
+		q.push("", []byte("response 2"))
+		if err := q.push("ev3", []byte("event 3")); err != ErrSendQueueOverflow {
+			t.Errorf("policy %d: expected overflow, got %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defRequestTimeout = time.Duration(30) * time.Second
+
+// FuncReply is a client message answering a server request,
+// query ID of the message is the ID of the request:
+// {"f": "reply", "q": "<query ID>", "p": {"result": ..., "error": "..."}}.
+const FuncReply = "reply"
+
+var ErrRequestTimeout = errors.New("client request timeout")
+
+// ClientReply is a payload of the reply message.
+// A non empty Error means the client failed to process the request.
+type ClientReply struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+type pendingRequest struct {
+	sessionID string
+	reply     chan ClientReply // buffered, the first reply wins
+}
+
+// serverRequests holds requests waiting for client replies, keyed by query ID.
+type serverRequests struct {
+	mx      sync.Mutex
+	pending map[string]*pendingRequest
+	seq     atomic.Uint64
+}
+
+func (r *serverRequests) add(sessionID string) (string, *pendingRequest) {
+	queryID := fmt.Sprintf("srv-%d", r.seq.Add(1))
+	req := &pendingRequest{sessionID: sessionID, reply: make(chan ClientReply, 1)}
+
+	r.mx.Lock()
+	if r.pending == nil {
+		r.pending = make(map[string]*pendingRequest)
+	}
+	r.pending[queryID] = req
+	r.mx.Unlock()
+
+	return queryID, req
+}
+
+func (r *serverRequests) remove(queryID string) {
+	r.mx.Lock()
+	delete(r.pending, queryID)
+	r.mx.Unlock()
+}
+
+// resolve passes the reply to the waiting request.
+// Replies from other sessions are ignored.
+func (r *serverRequests) resolve(sessionID, queryID string, reply ClientReply) bool {
+	r.mx.Lock()
+	req, ok := r.pending[queryID]
+	if ok && req.sessionID == sessionID {
+		delete(r.pending, queryID)
+	}
+	r.mx.Unlock()
+
+	if !ok || req.sessionID != sessionID {
+		return false
+	}
+	req.reply <- reply
+	return true
+}
+
+// RequestSession sends a request to all connections of the session and waits
+// for the first reply. The request is a SrvResponse with the method as EventID
+// and a server generated QueryID, the client answers with FuncReply message
+// carrying the same QueryID. Without a deadline in ctx the server request timeout is used.
+// Only clients connected to this node are asked.
+func (s *WSServer) RequestSession(ctx context.Context, sessionID, method string, payload any) (json.RawMessage, error) {
+	s.clientsMx.RLock()
+	targets := append([]*Client(nil), s.clients[sessionID]...)
+	s.clientsMx.RUnlock()
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("WSServer.RequestSession() session not found: %s", sessionID)
+	}
+	return s.request(ctx, sessionID, targets, method, payload)
+}
+
+// RequestClient is the same as RequestSession, but only the connection
+// with the given ID is asked.
+func (s *WSServer) RequestClient(ctx context.Context, sessionID, connID, method string, payload any) (json.RawMessage, error) {
+	client := s.clientByConnID(sessionID, connID)
+	if client == nil {
+		return nil, fmt.Errorf("WSServer.RequestClient() connection not found: %s", connID)
+	}
+	return s.request(ctx, sessionID, []*Client{client}, method, payload)
+}
+
+func (s *WSServer) request(ctx context.Context, sessionID string, targets []*Client, method string, payload any) (json.RawMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+
+	queryID, req := s.requests.add(sessionID)
+	defer s.requests.remove(queryID)
+
+	msg := SrvResponse{EventID: method, QueryID: queryID, Payload: payload}
+	var sent bool
+	for _, c := range targets {
+		if err := s.SendMessage(c, &msg); err == nil {
+			sent = true
+		}
+	}
+	if !sent {
+		return nil, fmt.Errorf("WSServer request %s: could not send to any connection", method)
+	}
+
+	select {
+	case reply := <-req.reply:
+		if reply.Error != "" {
+			return nil, fmt.Errorf("client %s: %s", method, reply.Error)
+		}
+		return reply.Result, nil
+
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrRequestTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// onClientReply handles FuncReply messages.
+func (s *WSServer) onClientReply(client *Client, msg *ClientMessage) error {
+	var reply ClientReply
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &reply); err != nil {
+			return fmt.Errorf("json.Unmarshal(): %v", err)
+		}
+	}
+	if !s.requests.resolve(client.ID, msg.QueryID, reply) {
+		return fmt.Errorf("no pending request for query ID: %s", msg.QueryID)
+	}
+	return nil
+}
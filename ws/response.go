@@ -7,9 +7,9 @@ import (
 	"net/http"
 
 	"github.com/dronm/crudifier"
+	"github.com/dronm/gobizapp/cluster"
 	"github.com/dronm/gobizapp/errs"
 	"github.com/dronm/gobizapp/logger"
-	"github.com/gorilla/websocket"
 )
 
 type SrvResponse struct {
@@ -62,18 +62,13 @@ func NewSrvResponseError(httpErr int, fnName string, isProduction bool, err erro
 }
 
 func (s *WSServer) SendMessage(c *Client, resp *SrvResponse) error {
-    respData, err := json.Marshal(resp)
+    respData, err := c.Codec.Marshal(resp)
     if err != nil {
-        logger.Logger.Errorf("WSServer SendMessage json.Marshal(): %v", err)
+        logger.Logger.Errorf("WSServer SendMessage Codec.Marshal(): %v", err)
         return fmt.Errorf("json marshal: %w", err)
     }
 
-    // All websocket writes MUST be serialized
-    c.writeMu.Lock()
-    err = c.Conn.WriteMessage(websocket.TextMessage, respData)
-    c.writeMu.Unlock()
-
-    if err != nil {
+    if err := c.Send(respData); err != nil {
         logger.Logger.Errorf("WSServer SendMessage WriteMessage(): %v", err)
         return fmt.Errorf("write message: %w", err)
     }
@@ -81,32 +76,47 @@ func (s *WSServer) SendMessage(c *Client, resp *SrvResponse) error {
     return nil
 }
 
+// SendMessageToClientID sends a message to all connections of the client ID.
+// With the cluster bus the message is also sent to other nodes, in this case
+// it is not an error if the client is not connected to this node.
 func (s *WSServer) SendMessageToClientID(clientID string, msg any) error {
     msgB, err := json.Marshal(msg)
     if err != nil {
         return err
     }
 
+	found, err := s.sendToClientIDLocal(clientID, msgB)
+
+	if s.cluster != nil {
+		s.publishToCluster(&cluster.Message{Kind: cluster.KindDirect, ClientID: clientID, Payload: msgB})
+		return err
+	}
+
+	if !found {
+        return fmt.Errorf("WSServer.SendMessageToClientID() client not found: %s", clientID)
+	}
+	return err
+}
+
+// sendToClientIDLocal sends encoded message to client connections of this node.
+// It returns false if there are no connections for the client.
+func (s *WSServer) sendToClientIDLocal(clientID string, msgB []byte) (bool, error) {
     s.clientsMx.RLock()
     conns := append([]*Client(nil), s.clients[clientID]...) // copy slice
     s.clientsMx.RUnlock()
 
     if len(conns) == 0 {
-        return fmt.Errorf("WSServer.SendMessageToClientID() client not found: %s", clientID)
+        return false, nil
     }
 
     for _, c := range conns {
-		logger.Logger.Debugf("WSServer.SendMessageToClientID(): clientID:%s, msg: %v", clientID, msg)
-        c.writeMu.Lock()
-        err := c.Conn.WriteMessage(websocket.TextMessage, msgB)
-        c.writeMu.Unlock()
-
-        if err != nil {
+		logger.Logger.Debugf("WSServer.SendMessageToClientID(): clientID:%s, msg: %s", clientID, msgB)
+        if err := c.SendJSON("", msgB); err != nil {
             go s.removeConn(clientID, c)
-            return err
+            return true, err
         }
     }
-    return nil
+    return true, nil
 }
 
 func (s *WSServer) HasClientID(clientID string) bool {
@@ -137,8 +147,39 @@ func (s *WSServer) SendMessageToClient(client []*Client, msg any) error {
 }
 */
 
-// PublishEvent sends SrvResponse with payload and eventID to all clients registered for this event. 
+// PublishEvent sends SrvResponse with payload and eventID to all clients registered for this event.
+// If there is an event policy for the event, the payload is passed through the policy
+// for every client and encoded separately.
+// The payload is marshaled once, this node and other cluster nodes deliver
+// the same json.RawMessage.
 func (s *WSServer) PublishEvent(publisherID, eventID string, payload any) error {
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("json.Marshal(): %v", err)
+	}
+	raw := json.RawMessage(payloadB)
+
+	if err := s.PublishLocalEvent(publisherID, eventID, raw); err != nil {
+		return err
+	}
+
+	if s.cluster != nil {
+		s.publishToCluster(&cluster.Message{
+			Kind:        cluster.KindEvent,
+			PublisherID: publisherID,
+			EventID:     eventID,
+			Payload:     raw,
+		})
+	}
+
+	return nil
+}
+
+// PublishLocalEvent is the same as PublishEvent but only clients of this node
+// receive the event. It is used for events which are delivered to every node
+// by other means, like database notifications.
+// Policy filters receive the payload as json.RawMessage whatever type is passed.
+func (s *WSServer) PublishLocalEvent(publisherID, eventID string, payload any) error {
     // 1. Build the message once
 	msg := SrvResponse{
 		QueryID: "", // Set this if needed
@@ -151,6 +192,18 @@ func (s *WSServer) PublishEvent(publisherID, eventID string, payload any) error
 		return fmt.Errorf("json.Marshal(): %v", err)
     }
 
+	var policy *EventPolicy
+	if s.eventPolicies != nil {
+		policy = s.eventPolicies.Lookup(eventID)
+	}
+	if _, ok := payload.(json.RawMessage); policy != nil && !ok {
+		payloadB, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("json.Marshal(): %v", err)
+		}
+		payload = json.RawMessage(payloadB)
+	}
+
     // 2. Copy all clients that subscribed to this event
     s.clientsMx.RLock()
     var targets []*Client
@@ -159,7 +212,7 @@ func (s *WSServer) PublishEvent(publisherID, eventID string, payload any) error
 			if c.ID == publisherID {
 				continue
 			}
-			if _, ok := c.events[eventID]; ok {
+			if c.HasEvent(eventID) {
                 targets = append(targets, c)
 			}
         }
@@ -167,31 +220,34 @@ func (s *WSServer) PublishEvent(publisherID, eventID string, payload any) error
     s.clientsMx.RUnlock()
 
     // 3. Send message outside of lock, per-client
+	encoded := map[string][]byte{CodecJSON: msgB} // shared message by codec name
     for _, c := range targets {
-        c.writeMu.Lock()
-        err := c.Conn.WriteMessage(websocket.TextMessage, msgB)
-        c.writeMu.Unlock()
+		var data []byte
+		if policy != nil {
+			clientPayload, ok := policy.Deliver(c.Session, s.clientRole(c), eventID, payload)
+			if !ok {
+				continue
+			}
+			clientMsg := msg
+			clientMsg.Payload = clientPayload
+			if data, err = c.Codec.Marshal(clientMsg); err != nil {
+				logger.Logger.Errorf("WSServer PublishEvent Codec.Marshal(): %v", err)
+				continue
+			}
+		} else if data = encoded[c.Codec.Name()]; data == nil {
+			if data, err = c.Codec.FromJSON(msgB); err != nil {
+				logger.Logger.Errorf("WSServer PublishEvent Codec.FromJSON(): %v", err)
+				continue
+			}
+			encoded[c.Codec.Name()] = data
+		}
 
-        if err != nil {
+        if err := c.SendEvent(eventID, data); err != nil {
             go func(clientID string, bad *Client) {
                 s.removeConn(clientID, bad)
             }(c.ID, c)
         }
     }
 
-	// s.clientsMx.RLock()
-	// defer s.clientsMx.RUnlock()
-	//
-	// for _, clientDevList := range s.clients {
-	// 	for _, clientDev := range clientDevList {
-	// 		if clientDev.ID == publisherID {
-	// 			continue
-	// 		}
-	// 		if _, ok := clientDev.events[eventID]; ok {
-	// 			s.SendMessage(clientDev.Conn, &msg)
-	// 		}
-	// 	}
-	// }
-
 	return nil
 }
@@ -5,13 +5,17 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	sess "github.com/dronm/session"
 
+	"github.com/dronm/gobizapp/cluster"
 	"github.com/dronm/gobizapp/logger"
 	"github.com/dronm/gobizapp/middleware"
+	"github.com/dronm/gobizapp/services"
 
 	"github.com/gin-gonic/gin"
 
@@ -20,7 +24,11 @@ import (
 
 var Server *WSServer
 
-const defMaxMethodCallDuration = time.Duration(1) * time.Minute
+const (
+	defMaxMethodCallDuration = time.Duration(1) * time.Minute
+	defPongWait              = time.Duration(60) * time.Second
+	defWriteWait             = time.Duration(10) * time.Second
+)
 
 type EventPubSub interface {
 	AddEvent(ID string)
@@ -38,9 +46,61 @@ type WSServer struct {
 	// clients is a client connections holder with mutex protection.
 	// keys is a client session ID
 	clients map[string][]*Client // clients is a client connections holder with mutex protection.
+	users   map[string]clientSet // connections by user ID, protected by clientsMx
+	// remoteUsers are connection counts of users on other nodes
+	// by user ID and node ID, protected by clientsMx
+	remoteUsers map[string]map[string]int
+	roles   map[string]clientSet // connections by role, protected by clientsMx
 
 	checkPermission CheckPermission
 	isMethodAllowed IsMethodAllowed
+
+	eventPolicies *EventPolicyRegistry
+	roleResolver  RoleResolver
+
+	cluster     cluster.Bus
+	nodeID      string
+	clusterOut  chan *cluster.Message // messages for other nodes
+	clusterStop chan struct{}
+	clusterDone chan struct{}
+	clusterOnce sync.Once
+	sessReqMx   sync.Mutex
+	sessReqs    map[string]chan []services.WSSessionInfo // admin session requests by request ID
+
+	sseURL  string
+	pollURL string
+
+	pingInterval time.Duration
+	pongWait     time.Duration
+	writeWait    time.Duration
+	idleTimeout  time.Duration
+	done         chan struct{} // closed on shutdown
+	doneOnce     sync.Once
+
+	sendQueueSize  int
+	overflowPolicy OverflowPolicy
+	queueCounters  queueCounters
+
+	maxConcurrentCalls int
+
+	requests       serverRequests
+	requestTimeout time.Duration
+
+	upgrader         *websocket.Upgrader
+	compressionLevel int
+
+	userResolver UserResolver
+
+	draining       atomic.Bool
+	inflight       atomic.Int64
+	drainTimeout   time.Duration
+	reconnectDelay time.Duration
+
+	sessManager    SessionManager
+	allowedOrigins []string
+	maxMessageSize int64
+	authenticate   AuthFunc
+	authTimeout    time.Duration
 }
 
 type SessionManager interface {
@@ -49,20 +109,48 @@ type SessionManager interface {
 }
 
 // These functions are used for checking if ws method is allowed.
+// RoleResolver returns user role from session, it is used by event policies.
 type (
 	CheckPermission = func(method string) gin.HandlerFunc
 	IsMethodAllowed = func(userSess sess.Session, method string) error
+	RoleResolver    = func(userSess sess.Session) string
+	UserResolver    = func(userSess sess.Session) string
 )
 
 type WSInit struct {
-	Addr            string
-	EventServer     EventPubSub
-	SessManager     SessionManager
-	CheckPermission CheckPermission
-	IsMethodAllowed IsMethodAllowed
-	IsProduction    bool
-	URL             string
-	SessCookieKey   string
+	Addr               string
+	EventServer        EventPubSub
+	SessManager        SessionManager
+	CheckPermission    CheckPermission
+	IsMethodAllowed    IsMethodAllowed
+	IsProduction       bool
+	URL                string
+	SessCookieKey      string
+	EventPolicies      *EventPolicyRegistry // optional, all events are allowed if not set
+	RoleResolver       RoleResolver         // used with EventPolicies
+	Cluster            cluster.Bus          // optional, for delivering messages to other app instances
+	NodeID             string               // unique node ID for Cluster, generated if empty
+	SSEURL             string               // server-sent events endpoint, URL/sse if empty
+	PollURL            string               // long polling endpoint, URL/poll if empty
+	NoFallback         bool                 // disables sse and long polling endpoints
+	PingInterval       time.Duration        // websocket ping period, 9/10 of PongWait if empty
+	PongWait           time.Duration        // max time to wait for any client frame, 60 seconds if empty
+	WriteWait          time.Duration        // max duration of one write, 10 seconds if empty
+	IdleTimeout        time.Duration        // clients without messages for this time are closed, 0 - never
+	SendQueueSize      int                  // per client outbound queue length, 256 if empty
+	OverflowPolicy     OverflowPolicy       // what to do when the queue is full
+	MaxConcurrentCalls int                  // per connection simultaneous method calls, 8 if empty
+	RequestTimeout     time.Duration        // default wait for client replies to server requests, 30 seconds if empty
+	EnableCompression  bool                 // negotiate permessage-deflate
+	CompressionLevel   int                  // flate level, library default if 0
+	AllowedOrigins     []string             // allowed Origin headers, e.g. CorsMiddleware base URL, "*" for any, same host if empty
+	Subprotocols       []string             // application subprotocols accepted in addition to codec names
+	MaxMessageSize     int64                // max client message size in bytes, no limit if 0
+	Authenticate       AuthFunc             // enables bearer token authentication instead of cookie sessions, routes are checked with IsMethodAllowed
+	AuthTimeout        time.Duration        // wait for the auth message, 10 seconds if empty
+	UserResolver       UserResolver         // returns user ID from session for user messages and presence
+	DrainTimeout       time.Duration        // max wait for in-flight calls on shutdown, 10 seconds if empty
+	ReconnectDelay     time.Duration        // base reconnect hint sent on shutdown, 2 seconds if empty
 }
 
 func NewWSServer(wsInit WSInit) *WSServer {
@@ -84,9 +172,72 @@ func NewWSServer(wsInit WSInit) *WSServer {
 			Addr:    wsInit.Addr,
 			Handler: router,
 		},
-		clients:         map[string][]*Client{},
-		checkPermission: wsInit.CheckPermission,
-		isMethodAllowed: wsInit.IsMethodAllowed,
+		clients:            map[string][]*Client{},
+		users:              map[string]clientSet{},
+		remoteUsers:        map[string]map[string]int{},
+		roles:              map[string]clientSet{},
+		userResolver:       wsInit.UserResolver,
+		drainTimeout:       wsInit.DrainTimeout,
+		reconnectDelay:     wsInit.ReconnectDelay,
+		checkPermission:    wsInit.CheckPermission,
+		isMethodAllowed:    wsInit.IsMethodAllowed,
+		eventPolicies:      wsInit.EventPolicies,
+		roleResolver:       wsInit.RoleResolver,
+		cluster:            wsInit.Cluster,
+		nodeID:             wsInit.NodeID,
+		pingInterval:       wsInit.PingInterval,
+		pongWait:           wsInit.PongWait,
+		writeWait:          wsInit.WriteWait,
+		idleTimeout:        wsInit.IdleTimeout,
+		done:               make(chan struct{}),
+		sendQueueSize:      wsInit.SendQueueSize,
+		overflowPolicy:     wsInit.OverflowPolicy,
+		maxConcurrentCalls: wsInit.MaxConcurrentCalls,
+		requestTimeout:     wsInit.RequestTimeout,
+		compressionLevel:   wsInit.CompressionLevel,
+		sessManager:        wsInit.SessManager,
+		allowedOrigins:     wsInit.AllowedOrigins,
+		maxMessageSize:     wsInit.MaxMessageSize,
+		authenticate:       wsInit.Authenticate,
+		authTimeout:        wsInit.AuthTimeout,
+	}
+	srv.upgrader = srv.newUpgrader(&wsInit)
+	if srv.authTimeout <= 0 {
+		srv.authTimeout = defAuthTimeout
+	}
+	if srv.drainTimeout <= 0 {
+		srv.drainTimeout = defDrainTimeout
+	}
+	if srv.reconnectDelay <= 0 {
+		srv.reconnectDelay = defReconnectDelay
+	}
+	if srv.pongWait <= 0 {
+		srv.pongWait = defPongWait
+	}
+	if srv.pingInterval <= 0 || srv.pingInterval >= srv.pongWait {
+		srv.pingInterval = srv.pongWait * 9 / 10
+	}
+	if srv.writeWait <= 0 {
+		srv.writeWait = defWriteWait
+	}
+	if srv.requestTimeout <= 0 {
+		srv.requestTimeout = defRequestTimeout
+	}
+
+	if srv.cluster != nil {
+		if srv.nodeID == "" {
+			srv.nodeID = cluster.NewNodeID()
+		}
+		if err := srv.cluster.Subscribe(srv.onClusterMessage); err != nil {
+			logger.Logger.Errorf("WSServer cluster Subscribe(): %v", err)
+		}
+		srv.clusterOut = make(chan *cluster.Message, clusterQueueSize)
+		srv.clusterStop = make(chan struct{})
+		srv.clusterDone = make(chan struct{})
+		srv.sessReqs = make(map[string]chan []services.WSSessionInfo)
+		go srv.clusterPublisher()
+		// other nodes report their connected users
+		srv.publishToCluster(&cluster.Message{Kind: cluster.KindPresenceSync})
 	}
 
 	router.Use(middleware.SessionMiddleware(wsInit.SessManager, wsInit.SessCookieKey, wsInit.IsProduction))
@@ -94,9 +245,22 @@ func NewWSServer(wsInit WSInit) *WSServer {
 	if wsInit.URL == "" {
 		wsInit.URL = "/"
 	}
-	router.GET(wsInit.URL, srv.checkPermission("WS.Init"), srv.Init)
+	router.GET(wsInit.URL, srv.routePermission("WS.Init"), srv.Init)
 	// router.GET(wsInit.URL, srv.Init)
 
+	if !wsInit.NoFallback {
+		if wsInit.SSEURL == "" {
+			wsInit.SSEURL = path.Join(wsInit.URL, "sse")
+		}
+		if wsInit.PollURL == "" {
+			wsInit.PollURL = path.Join(wsInit.URL, "poll")
+		}
+		srv.sseURL = wsInit.SSEURL
+		srv.pollURL = wsInit.PollURL
+		router.GET(wsInit.SSEURL, srv.routePermission("WS.SSE"), srv.InitSSE)
+		router.GET(wsInit.PollURL, srv.routePermission("WS.Poll"), srv.Poll)
+	}
+
 	return srv
 }
 
@@ -108,13 +272,31 @@ func (s *WSServer) Serve(cleanupConnInterval int) {
 		}
 	}()
 
-	// if cleanupConnInterval > 0 {
-	// 	go s.CleanupConnections(time.Duration(cleanupConnInterval) * time.Millisecond)
-	// }
+	if cleanupConnInterval > 0 && s.idleTimeout > 0 {
+		go s.CleanupConnections(time.Duration(cleanupConnInterval) * time.Millisecond)
+	}
+}
+
+// Handler returns the http handler of the server, it can be used
+// with external listeners, e.g. httptest.
+func (s *WSServer) Handler() http.Handler {
+	return s.server.Handler
 }
 
+// Shutdown drains connections, see drain, and stops the http server.
 func (s *WSServer) Shutdown(ctx context.Context) {
-	s.ShutdownWebSockets(ctx)
+	s.doneOnce.Do(func() { close(s.done) })
+	s.drain(ctx)
+	if s.cluster != nil {
+		s.clusterOnce.Do(func() { close(s.clusterStop) })
+		select {
+		case <-s.clusterDone:
+		case <-ctx.Done():
+		}
+		if err := s.cluster.Close(); err != nil {
+			logger.Logger.Errorf("WSServer cluster Close(): %v", err)
+		}
+	}
 	// Attempt to gracefully shut down the server
 	if err := s.server.Shutdown(ctx); err != nil {
 		logger.Logger.Fatalf("WSServer forced to shutdown: %s\n", err)
@@ -122,80 +304,50 @@ func (s *WSServer) Shutdown(ctx context.Context) {
 	logger.Logger.Info("WSServer gracefully shutdown")
 }
 
+// CleanupConnections closes clients without activity for longer than IdleTimeout.
+// Dead websocket peers are detected by ping/pong in HandleConnection,
+// here only idle connections are handled. Server-sent events clients can not
+// send anything, so they are never idle.
 func (s *WSServer) CleanupConnections(interval time.Duration) {
 	logger.Logger.Infof("WSServer starting cleanup connections with interval: %v", interval)
 
-	for {
-		time.Sleep(interval)
-
-		logger.Logger.Warn("WSServer running cleanup connections")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		s.clientsMx.Lock()
-		for sessionID, clientList := range s.clients {
-			var activeClients []*Client
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
 
+		// collect stale clients under lock, close them outside
+		var stale []*Client
+		s.clientsMx.RLock()
+		for _, clientList := range s.clients {
 			for _, client := range clientList {
-				if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					logger.Logger.Warnf("WSServer closing stale socket for session %s", sessionID)
-					client.Conn.Close()
-					client.RemoveAllEvents()
-				} else {
-					activeClients = append(activeClients, client)
+				if client.Transport.Name() == TransportSSE {
+					continue
+				}
+				if time.Since(client.Visited()) > s.idleTimeout {
+					stale = append(stale, client)
 				}
 			}
-
-			if len(activeClients) > 0 {
-				s.clients[sessionID] = activeClients
-			} else {
-				delete(s.clients, sessionID)
-			}
-		}
-		s.clientsMx.Unlock()
-	}
-}
-
-func (s *WSServer) ShutdownWebSockets(ctx context.Context) {
-	s.clientsMx.Lock()
-	conns := make([]*websocket.Conn, 0)
-	for _, clientList := range s.clients {
-		for _, client := range clientList {
-			conns = append(conns, client.Conn)
 		}
-	}
-	s.clientsMx.Unlock()
-
-	for _, conn := range conns {
-		if ctx.Err() != nil {
-			break
-		}
-
-		// Add per-connection timeout
-		connCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-		errChan := make(chan error, 1)
+		s.clientsMx.RUnlock()
 
-		go func(c *websocket.Conn) {
-			err := c.WriteMessage(
-				websocket.CloseMessage,
-				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "WSServer shutting down"),
-			)
-			logger.Logger.Warnf("WSServer closing socket on shutdown")
-			c.Close()
-			errChan <- err
-		}(conn)
-
-		select {
-		case <-connCtx.Done():
-			// Timeout or shutdown
-		case <-ctx.Done():
-			// Global context canceled
-		case <-errChan:
-			// Success or error from WriteMessage
+		for _, client := range stale {
+			logger.Logger.Warnf("WSServer closing idle connection %s for session %s", client.ConnID, client.ID)
+			if t, ok := client.Transport.(*wsTransport); ok {
+				t.closeWithCode(websocket.CloseGoingAway, "idle timeout")
+			}
+			s.removeConn(client.ID, client)
 		}
-
-		cancel()
 	}
 }
 
+// SubscribeToEvent subscribes all session clients to the event.
+// If event policies are defined, the subscription is checked against them.
 func (s *WSServer) SubscribeToEvent(sessionID, eventID string) error {
 	s.clientsMx.RLock()
 	defer s.clientsMx.RUnlock()
@@ -205,6 +357,12 @@ func (s *WSServer) SubscribeToEvent(sessionID, eventID string) error {
 		return fmt.Errorf("WSServer SubscribeToEvent(): session not found by ID")
 	}
 
+	if s.eventPolicies != nil {
+		if err := s.eventPolicies.CanSubscribe(clients[0].Session, s.clientRole(clients[0]), eventID); err != nil {
+			return err
+		}
+	}
+
 	for _, client := range clients {
 		client.AddEvent(eventID)
 	}
@@ -212,6 +370,14 @@ func (s *WSServer) SubscribeToEvent(sessionID, eventID string) error {
 	return nil
 }
 
+// clientRole returns client session role if RoleResolver is set.
+func (s *WSServer) clientRole(c *Client) string {
+	if s.roleResolver == nil || c.Session == nil {
+		return ""
+	}
+	return s.roleResolver(c.Session)
+}
+
 func (s *WSServer) UnsubscribeFromEvent(sessionID, eventID string) error {
 	s.clientsMx.RLock()
 	defer s.clientsMx.RUnlock()
@@ -228,24 +394,58 @@ func (s *WSServer) UnsubscribeFromEvent(sessionID, eventID string) error {
 	return nil
 }
 
-func (s *WSServer) removeConn(clientID string, target *Client) {
-    s.clientsMx.Lock()
-    defer s.clientsMx.Unlock()
-
-    list := s.clients[clientID]
-    out := list[:0]
-    for _, c := range list {
-        if c == target {
-            c.Conn.Close()
-        } else {
-            out = append(out, c)
-        }
-    }
-
-    if len(out) == 0 {
-        delete(s.clients, clientID)
-    } else {
-        s.clients[clientID] = out
-    }
+// addClient registers a new client connection and starts its send queue.
+// A client with a failed write is removed.
+func (s *WSServer) addClient(client *Client) {
+	client.startQueue(s.sendQueueSize, s.overflowPolicy, &s.queueCounters, func(err error) {
+		logger.Logger.Warnf("WSServer write to %s failed: %v, removing connection", client.ConnID, err)
+		s.removeConn(client.ID, client)
+	})
+
+	if s.userResolver != nil && client.Session != nil {
+		client.UserID = s.userResolver(client.Session)
+	}
+	client.Role = s.clientRole(client)
+
+	s.clientsMx.Lock()
+	s.clients[client.ID] = append(s.clients[client.ID], client)
+	online := s.indexClient(client)
+	s.publishNodePresence(client)
+	s.clientsMx.Unlock()
+
+	if online {
+		s.publishPresence(EventUserOnline, client.UserID, client.Role)
+	}
 }
 
+// removeConn closes client connection, removes it from the list
+// and unsubscribes its events. It does nothing if the client is already removed.
+func (s *WSServer) removeConn(clientID string, target *Client) {
+	var offline bool
+	s.clientsMx.Lock()
+
+	list := s.clients[clientID]
+	out := list[:0]
+	for _, c := range list {
+		if c == target {
+			c.stopQueue()
+			c.Transport.Close()
+			c.RemoveAllEvents()
+			offline = s.unindexClient(c)
+			s.publishNodePresence(c)
+		} else {
+			out = append(out, c)
+		}
+	}
+
+	if len(out) == 0 {
+		delete(s.clients, clientID)
+	} else {
+		s.clients[clientID] = out
+	}
+	s.clientsMx.Unlock()
+
+	if offline {
+		s.publishPresence(EventUserOffline, target.UserID, target.Role)
+	}
+}
@@ -0,0 +1,136 @@
+package ws
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/dronm/gobizapp/controllers"
+	"github.com/dronm/gobizapp/logger"
+)
+
+const (
+	defSSEKeepAlive = time.Duration(25) * time.Second
+
+	// EventConnected is the first event sent to sse clients, its payload is the connection ID.
+	EventConnected = "Connected"
+)
+
+// sseTransport sends messages as server-sent events.
+type sseTransport struct {
+	mx      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	done    chan struct{}
+	closed  bool
+}
+
+func newSSETransport(w http.ResponseWriter, flusher http.Flusher) *sseTransport {
+	return &sseTransport{w: w, flusher: flusher, done: make(chan struct{})}
+}
+
+func (t *sseTransport) Name() string {
+	return TransportSSE
+}
+
+func (t *sseTransport) Send(data []byte) error {
+	return t.write("data: " + string(data) + "\n\n")
+}
+
+// retry sets client reconnection time in milliseconds.
+func (t *sseTransport) retry(ms int64) error {
+	return t.write("retry: " + strconv.FormatInt(ms, 10) + "\n\n")
+}
+
+// keepAlive sends a comment line to keep proxies from closing the connection.
+func (t *sseTransport) keepAlive() error {
+	return t.write(": ping\n\n")
+}
+
+func (t *sseTransport) write(s string) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.closed {
+		return fmt.Errorf("sse transport is closed")
+	}
+	if _, err := t.w.Write([]byte(s)); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+func (t *sseTransport) Close() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	return nil
+}
+
+// InitSSE is a server-sent events endpoint for clients which can not use websockets.
+// Events are delivered the same way as for websocket clients, subscription is done
+// with EventService over regular http API with the same session.
+// The first event has "Connected" ID and contains connection ID as payload.
+func (s *WSServer) InitSSE(c *gin.Context) {
+	funcName := "WSServer.InitSSE"
+	if s.rejectDraining(c, funcName) {
+		return
+	}
+	sess, ok := s.requestSession(c, funcName, "WS.SSE")
+	if !ok {
+		return
+	}
+	if sess == nil {
+		controllers.ServeError(c, http.StatusUnauthorized, funcName, fmt.Errorf("%s query parameter expected", TokenQueryParam))
+		return
+	}
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		controllers.ServeError(c, http.StatusInternalServerError, funcName, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no") // nginx
+	c.Status(http.StatusOK)
+
+	t := newSSETransport(c.Writer, flusher)
+	client := NewTransportClient(sess.SessionID(), t, s.EventServer, sess)
+	client.setRequestInfo(c.Request, c.ClientIP())
+	s.addClient(client)
+	defer s.removeConn(client.ID, client)
+
+	logger.Logger.Debugf("WSServer InitSSE: adding new client with ID: %s", client.ID)
+
+	if err := s.SendMessage(client, &SrvResponse{EventID: EventConnected, Payload: client.ConnID}); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(defSSEKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-t.done:
+			return
+		case <-ticker.C:
+			if err := t.keepAlive(); err != nil {
+				return
+			}
+		}
+	}
+}
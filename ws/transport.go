@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	TransportWS   = "ws"
+	TransportSSE  = "sse"
+	TransportPoll = "poll"
+)
+
+// Transport delivers encoded server messages to a client connection.
+// Every client connection has its own transport: websocket, server-sent events
+// or long polling. Send must be safe for concurrent use.
+type Transport interface {
+	Name() string
+	Send(data []byte) error
+	Close() error
+}
+
+// wsTransport sends messages over websocket connection.
+type wsTransport struct {
+	conn      *websocket.Conn
+	writeMu   sync.Mutex // all websocket writes MUST be serialized
+	writeWait time.Duration
+	msgType   int // text or binary frames, depends on codec
+}
+
+func newWSTransport(conn *websocket.Conn, writeWait time.Duration) *wsTransport {
+	if writeWait <= 0 {
+		writeWait = defWriteWait
+	}
+	return &wsTransport{conn: conn, writeWait: writeWait, msgType: websocket.TextMessage}
+}
+
+func (t *wsTransport) Name() string {
+	return TransportWS
+}
+
+func (t *wsTransport) Send(data []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	if err := t.conn.SetWriteDeadline(time.Now().Add(t.writeWait)); err != nil {
+		return err
+	}
+	return t.conn.WriteMessage(t.msgType, data)
+}
+
+// ping sends a ping control frame, WriteControl is safe for concurrent use.
+func (t *wsTransport) ping() error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(t.writeWait))
+}
+
+// closeWithCode sends a close frame with the code and text and closes the connection.
+func (t *wsTransport) closeWithCode(code int, text string) error {
+	err := t.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(t.writeWait),
+	)
+	t.conn.Close()
+	return err
+}
+
+func (t *wsTransport) Close() error {
+	return t.conn.Close()
+}
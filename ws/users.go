@@ -0,0 +1,257 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/dronm/gobizapp/cluster"
+	"github.com/dronm/gobizapp/logger"
+)
+
+// Presence events, clients subscribe to them through EventService.
+// With the cluster bus every node publishes connection counts of its users,
+// a user is online while any node has connections. Every node delivers
+// the events to its own clients when the aggregated state changes.
+// Counts of a node stopped without Shutdown stay until it is restarted.
+const (
+	EventUserOnline  = "User.Online"
+	EventUserOffline = "User.Offline"
+)
+
+// PresencePayload is a payload of presence events.
+type PresencePayload struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// nodePresence is a connection count of a user on one node.
+type nodePresence struct {
+	UserID string `json:"u"`
+	Role   string `json:"r,omitempty"`
+	Count  int    `json:"c"`
+}
+
+// clientSet is a set of connections.
+type clientSet map[*Client]struct{}
+
+// indexClient adds the client to user and role indexes, it should be called under clientsMx lock.
+// It returns true if this is the first connection of the user on all nodes.
+func (s *WSServer) indexClient(c *Client) bool {
+	var online bool
+	if c.UserID != "" {
+		set, ok := s.users[c.UserID]
+		if !ok {
+			set = make(clientSet)
+			s.users[c.UserID] = set
+			online = len(s.remoteUsers[c.UserID]) == 0
+		}
+		set[c] = struct{}{}
+	}
+	if c.Role != "" {
+		set, ok := s.roles[c.Role]
+		if !ok {
+			set = make(clientSet)
+			s.roles[c.Role] = set
+		}
+		set[c] = struct{}{}
+	}
+	return online
+}
+
+// unindexClient removes the client from indexes, it should be called under clientsMx lock.
+// It returns true if this was the last connection of the user on all nodes.
+func (s *WSServer) unindexClient(c *Client) bool {
+	var offline bool
+	if set, ok := s.users[c.UserID]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(s.users, c.UserID)
+			offline = len(s.remoteUsers[c.UserID]) == 0
+		}
+	}
+	if set, ok := s.roles[c.Role]; ok {
+		delete(set, c)
+		if len(set) == 0 {
+			delete(s.roles, c.Role)
+		}
+	}
+	return offline
+}
+
+// publishPresence sends the presence event to clients of this node,
+// other nodes send it to their clients themselves, see onPresence.
+func (s *WSServer) publishPresence(eventID, userID, role string) {
+	if err := s.PublishLocalEvent("", eventID, PresencePayload{UserID: userID, Role: role}); err != nil {
+		logger.Logger.Errorf("WSServer publishPresence %s: %v", eventID, err)
+	}
+}
+
+// publishNodePresence sends the connection count of the client user on this node
+// to other nodes. It should be called under clientsMx lock so counts are queued
+// in the order of changes.
+func (s *WSServer) publishNodePresence(c *Client) {
+	if s.cluster == nil || c.UserID == "" {
+		return
+	}
+	s.publishPresenceCounts([]nodePresence{{UserID: c.UserID, Role: c.Role, Count: len(s.users[c.UserID])}})
+}
+
+// replyPresence sends connection counts of all users of this node.
+func (s *WSServer) replyPresence() {
+	s.clientsMx.RLock()
+	defer s.clientsMx.RUnlock()
+
+	list := make([]nodePresence, 0, len(s.users))
+	for userID, set := range s.users {
+		var role string
+		for c := range set {
+			role = c.Role
+			break
+		}
+		list = append(list, nodePresence{UserID: userID, Role: role, Count: len(set)})
+	}
+	if len(list) > 0 {
+		s.publishPresenceCounts(list)
+	}
+}
+
+func (s *WSServer) publishPresenceCounts(list []nodePresence) {
+	payloadB, err := json.Marshal(list)
+	if err != nil {
+		logger.Logger.Errorf("WSServer publishPresenceCounts json.Marshal(): %v", err)
+		return
+	}
+	s.publishToCluster(&cluster.Message{Kind: cluster.KindPresence, Payload: payloadB})
+}
+
+// onPresence stores connection counts of another node and sends presence
+// events to clients of this node for users whose aggregated state is changed.
+func (s *WSServer) onPresence(msg *cluster.Message) {
+	var list []nodePresence
+	if err := json.Unmarshal(msg.Payload, &list); err != nil {
+		logger.Logger.Errorf("WSServer onPresence json.Unmarshal(): %v", err)
+		return
+	}
+
+	var changed []nodePresence
+	s.clientsMx.Lock()
+	for _, p := range list {
+		if p.UserID == "" {
+			continue
+		}
+		wasOnline := s.userOnline(p.UserID)
+		nodes := s.remoteUsers[p.UserID]
+		if p.Count > 0 {
+			if nodes == nil {
+				nodes = make(map[string]int)
+				s.remoteUsers[p.UserID] = nodes
+			}
+			nodes[msg.NodeID] = p.Count
+		} else if nodes != nil {
+			delete(nodes, msg.NodeID)
+			if len(nodes) == 0 {
+				delete(s.remoteUsers, p.UserID)
+			}
+		}
+		if s.userOnline(p.UserID) != wasOnline {
+			changed = append(changed, p)
+		}
+	}
+	s.clientsMx.Unlock()
+
+	for _, p := range changed {
+		if p.Count > 0 {
+			s.publishPresence(EventUserOnline, p.UserID, p.Role)
+		} else {
+			s.publishPresence(EventUserOffline, p.UserID, p.Role)
+		}
+	}
+}
+
+// userOnline returns true if the user has connections on any node,
+// it should be called under clientsMx lock.
+func (s *WSServer) userOnline(userID string) bool {
+	if _, ok := s.users[userID]; ok {
+		return true
+	}
+	return len(s.remoteUsers[userID]) > 0
+}
+
+// IsUserOnline returns true if the user has connections on any node.
+func (s *WSServer) IsUserOnline(userID string) bool {
+	s.clientsMx.RLock()
+	defer s.clientsMx.RUnlock()
+
+	return s.userOnline(userID)
+}
+
+// OnlineUsers returns IDs of users connected to any node.
+func (s *WSServer) OnlineUsers() []string {
+	s.clientsMx.RLock()
+	defer s.clientsMx.RUnlock()
+
+	list := make([]string, 0, len(s.users)+len(s.remoteUsers))
+	for userID := range s.users {
+		list = append(list, userID)
+	}
+	for userID := range s.remoteUsers {
+		if _, ok := s.users[userID]; !ok {
+			list = append(list, userID)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// SendMessageToUser sends a message to all connections of the user on all devices.
+// With the cluster bus the message is also sent to other nodes.
+func (s *WSServer) SendMessageToUser(userID string, msg any) error {
+	msgB, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	found := s.sendToSetLocal(s.users, userID, msgB)
+
+	if s.cluster != nil {
+		s.publishToCluster(&cluster.Message{Kind: cluster.KindUser, UserID: userID, Payload: msgB})
+		return nil
+	}
+	if !found {
+		return fmt.Errorf("WSServer.SendMessageToUser() user not connected: %s", userID)
+	}
+	return nil
+}
+
+// SendMessageToRole sends a message to all connections of users with the role.
+// With the cluster bus the message is also sent to other nodes.
+func (s *WSServer) SendMessageToRole(role string, msg any) error {
+	msgB, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.sendToSetLocal(s.roles, role, msgB)
+
+	if s.cluster != nil {
+		s.publishToCluster(&cluster.Message{Kind: cluster.KindRole, Role: role, Payload: msgB})
+	}
+	return nil
+}
+
+// sendToSetLocal sends JSON encoded message to the indexed connections of this node.
+// It returns false if there are no connections.
+func (s *WSServer) sendToSetLocal(index map[string]clientSet, key string, msgB []byte) bool {
+	s.clientsMx.RLock()
+	targets := make([]*Client, 0, len(index[key]))
+	for c := range index[key] {
+		targets = append(targets, c)
+	}
+	s.clientsMx.RUnlock()
+
+	for _, c := range targets {
+		if err := c.SendJSON("", msgB); err != nil {
+			go s.removeConn(c.ID, c)
+		}
+	}
+	return len(targets) > 0
+}
@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dronm/gobizapp/cluster"
+)
+
+func presenceMsg(t *testing.T, nodeID string, list ...nodePresence) *cluster.Message {
+	t.Helper()
+	payloadB, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cluster.Message{NodeID: nodeID, Kind: cluster.KindPresence, Payload: payloadB}
+}
+
+func TestPresenceAcrossNodes(t *testing.T) {
+	s := &WSServer{
+		clients:     map[string][]*Client{},
+		users:       map[string]clientSet{},
+		roles:       map[string]clientSet{},
+		remoteUsers: map[string]map[string]int{},
+	}
+
+	s.onPresence(presenceMsg(t, "node-b", nodePresence{UserID: "u1", Count: 2}))
+	if !s.IsUserOnline("u1") {
+		t.Fatal("user connected to another node is offline")
+	}
+
+	c := &Client{UserID: "u1"}
+	if s.indexClient(c) {
+		t.Error("online is reported for a user already connected to another node")
+	}
+	if s.unindexClient(c) {
+		t.Error("offline is reported while another node has connections")
+	}
+
+	s.onPresence(presenceMsg(t, "node-b", nodePresence{UserID: "u1", Count: 0}))
+	if s.IsUserOnline("u1") {
+		t.Error("user without connections is online")
+	}
+	if users := s.OnlineUsers(); len(users) != 0 {
+		t.Errorf("unexpected online users: %v", users)
+	}
+}
@@ -0,0 +1,315 @@
+package wstest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sess "github.com/dronm/session"
+	"github.com/gorilla/websocket"
+
+	"github.com/dronm/gobizapp/ws"
+)
+
+const (
+	defTimeout      = time.Duration(5) * time.Second
+	eventsBufferLen = 256
+)
+
+var (
+	ErrTimeout = errors.New("wstest: timeout")
+	ErrClosed  = errors.New("wstest: connection closed")
+)
+
+// Response is a server message with undecoded payload.
+type Response struct {
+	EventID string               `json:"event_id"`
+	QueryID string               `json:"query_id"`
+	Payload json.RawMessage      `json:"payload"`
+	Error   *ws.SrvResponseError `json:"error"`
+}
+
+// Decode unmarshals the payload.
+func (r *Response) Decode(v any) error {
+	return json.Unmarshal(r.Payload, v)
+}
+
+// Client is a websocket client of the test server.
+// Responses are matched to calls by query ID, all other messages
+// are events which are kept until waited for.
+type Client struct {
+	Session sess.Session
+	Timeout time.Duration // wait for responses and events, 5 seconds by default
+
+	srv  *Server
+	conn *websocket.Conn
+	seq  atomic.Uint64
+
+	writeMu sync.Mutex
+
+	mx      sync.Mutex
+	pending map[string]chan *Response
+	events  []*Response
+	newEv   chan struct{}
+	closed  chan struct{}
+	readErr error
+}
+
+// Dial connects to the server with the session, a new session is created if it is nil.
+func (s *Server) Dial(userSess sess.Session) (*Client, error) {
+	if userSess == nil {
+		var err error
+		if userSess, err = s.Sessions.SessionStart(""); err != nil {
+			return nil, err
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Cookie", (&http.Cookie{Name: s.cookieKey, Value: userSess.SessionID()}).String())
+	conn, _, err := websocket.DefaultDialer.Dial(s.URL, header)
+	if err != nil {
+		return nil, fmt.Errorf("websocket Dial(): %v", err)
+	}
+
+	c := &Client{
+		Session: userSess,
+		Timeout: defTimeout,
+		srv:     s,
+		conn:    conn,
+		pending: make(map[string]chan *Response),
+		newEv:   make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	go c.read()
+
+	return c, nil
+}
+
+// MustDial is Dial failing the test on error.
+func (s *Server) MustDial(t testing.TB, userSess sess.Session) *Client {
+	t.Helper()
+	c, err := s.Dial(userSess)
+	if err != nil {
+		t.Fatalf("wstest Dial(): %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *Client) read() {
+	defer close(c.closed)
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			c.mx.Lock()
+			c.readErr = err
+			c.mx.Unlock()
+			return
+		}
+		resp := &Response{}
+		if err := json.Unmarshal(msg, resp); err != nil {
+			continue
+		}
+
+		c.mx.Lock()
+		if ch, ok := c.pending[resp.QueryID]; ok && resp.EventID == "Response" {
+			delete(c.pending, resp.QueryID)
+			ch <- resp
+		} else {
+			c.events = append(c.events, resp)
+			select {
+			case c.newEv <- struct{}{}:
+			default:
+			}
+		}
+		c.mx.Unlock()
+	}
+}
+
+func (c *Client) write(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+type clientMessage struct {
+	Func    string `json:"f"`
+	QueryID string `json:"q"`
+	Payload any    `json:"p"`
+}
+
+// Send sends Service.Method call without waiting for the response
+// and returns its query ID. Parameters are positional, so params should be
+// a struct or json.RawMessage with fields in method parameter order, not a map.
+func (c *Client) Send(f string, params any) (string, error) {
+	queryID := strconv.FormatUint(c.seq.Add(1), 10)
+	if params == nil {
+		params = struct{}{}
+	}
+	return queryID, c.write(clientMessage{Func: f, QueryID: queryID, Payload: params})
+}
+
+// Call sends Service.Method call and waits for the response.
+// Methods returning no payload send nothing on success, ErrTimeout
+// is returned for them.
+func (c *Client) Call(f string, params any) (*Response, error) {
+	queryID := strconv.FormatUint(c.seq.Add(1), 10)
+	ch := make(chan *Response, 1)
+
+	c.mx.Lock()
+	c.pending[queryID] = ch
+	c.mx.Unlock()
+	defer func() {
+		c.mx.Lock()
+		delete(c.pending, queryID)
+		c.mx.Unlock()
+	}()
+
+	if params == nil {
+		params = struct{}{}
+	}
+	if err := c.write(clientMessage{Func: f, QueryID: queryID, Payload: params}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-c.closed:
+		return nil, ErrClosed
+	case <-time.After(c.Timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// MustCall calls the method, fails the test on any error including
+// error responses and decodes the payload into out if it is not nil.
+// Response payload is a list of method results without the error.
+func (c *Client) MustCall(t testing.TB, f string, params any, out any) {
+	t.Helper()
+	resp, err := c.Call(f, params)
+	if err != nil {
+		t.Fatalf("wstest Call(%s): %v", f, err)
+	}
+	if resp.Error != nil {
+		t.Fatalf("wstest Call(%s): error response %s: %s", f, resp.Error.Code, resp.Error.Message)
+	}
+	if out != nil {
+		if err := resp.Decode(out); err != nil {
+			t.Fatalf("wstest Call(%s): Decode(): %v", f, err)
+		}
+	}
+}
+
+// Cancel sends the cancel control message for the call.
+func (c *Client) Cancel(queryID string) error {
+	return c.write(clientMessage{Func: ws.FuncCancel, QueryID: queryID})
+}
+
+// Reply answers a server request.
+func (c *Client) Reply(queryID string, result any) error {
+	res, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.write(clientMessage{Func: ws.FuncReply, QueryID: queryID, Payload: ws.ClientReply{Result: res}})
+}
+
+// Subscribe subscribes the client session to events directly through the server.
+func (c *Client) Subscribe(events ...string) error {
+	for _, ev := range events {
+		if err := c.srv.WS.SubscribeToEvent(c.Session.SessionID(), ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitEvent waits for the event, events received before are checked first.
+// Other events are kept for later calls.
+func (c *Client) WaitEvent(eventID string) (*Response, error) {
+	return c.waitEvent(eventID, c.Timeout)
+}
+
+func (c *Client) waitEvent(eventID string, d time.Duration) (*Response, error) {
+	timeout := time.After(d)
+	for {
+		if ev := c.takeEvent(eventID); ev != nil {
+			return ev, nil
+		}
+		select {
+		case <-c.newEv:
+		case <-c.closed:
+			if ev := c.takeEvent(eventID); ev != nil {
+				return ev, nil
+			}
+			return nil, ErrClosed
+		case <-timeout:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// ExpectEvent waits for the event failing the test if it does not come
+// and decodes the payload into out if it is not nil.
+func (c *Client) ExpectEvent(t testing.TB, eventID string, out any) *Response {
+	t.Helper()
+	ev, err := c.WaitEvent(eventID)
+	if err != nil {
+		t.Fatalf("wstest WaitEvent(%s): %v", eventID, err)
+	}
+	if out != nil {
+		if err := ev.Decode(out); err != nil {
+			t.Fatalf("wstest WaitEvent(%s): Decode(): %v", eventID, err)
+		}
+	}
+	return ev
+}
+
+// ExpectNoEvent fails the test if the event comes within d.
+func (c *Client) ExpectNoEvent(t testing.TB, eventID string, d time.Duration) {
+	t.Helper()
+	if ev, _ := c.waitEvent(eventID, d); ev != nil {
+		t.Fatalf("wstest: unexpected event %s: %s", eventID, ev.Payload)
+	}
+}
+
+func (c *Client) takeEvent(eventID string) *Response {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	for i, ev := range c.events {
+		if ev.EventID == eventID {
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return ev
+		}
+	}
+	return nil
+}
+
+// Err returns the read error after the connection is closed.
+func (c *Client) Err() error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.readErr
+}
+
+// Close closes the connection.
+func (c *Client) Close() {
+	c.writeMu.Lock()
+	_ = c.conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.writeMu.Unlock()
+	c.conn.Close()
+	<-c.closed
+}
@@ -0,0 +1,84 @@
+package wstest
+
+import (
+	"sync"
+)
+
+// PublishedEvent is an event recorded by EventServer.
+type PublishedEvent struct {
+	PublisherID string
+	EventID     string
+	Payload     any
+}
+
+// SocketServer receives published events, it is implemented by ws.WSServer.
+type SocketServer interface {
+	PublishEvent(publisherID, eventID string, payload any) error
+}
+
+// EventServer is a fake event server. It counts subscriptions as
+// eventServer.EventServer does without listening to the database,
+// records published events and passes them to the socket server if set.
+type EventServer struct {
+	mx        sync.Mutex
+	events    map[string]int
+	published []PublishedEvent
+
+	SocketServer SocketServer // optional
+}
+
+func NewEventServer() *EventServer {
+	return &EventServer{events: make(map[string]int)}
+}
+
+// AddEvent increases event subscription count.
+func (s *EventServer) AddEvent(ID string) {
+	s.mx.Lock()
+	s.events[ID]++
+	s.mx.Unlock()
+}
+
+// RemoveEvent decreases event subscription count.
+func (s *EventServer) RemoveEvent(ID string) {
+	s.mx.Lock()
+	if s.events[ID] <= 1 {
+		delete(s.events, ID)
+	} else {
+		s.events[ID]--
+	}
+	s.mx.Unlock()
+}
+
+// Subscribers returns the number of subscriptions to the event.
+func (s *EventServer) Subscribers(ID string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.events[ID]
+}
+
+// PublishEvent records the event and passes it to the socket server.
+func (s *EventServer) PublishEvent(publisherID, eventID string, payload any) error {
+	s.mx.Lock()
+	s.published = append(s.published, PublishedEvent{PublisherID: publisherID, EventID: eventID, Payload: payload})
+	srv := s.SocketServer
+	s.mx.Unlock()
+
+	if srv != nil {
+		return srv.PublishEvent(publisherID, eventID, payload)
+	}
+	return nil
+}
+
+// Published returns recorded events.
+func (s *EventServer) Published() []PublishedEvent {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]PublishedEvent(nil), s.published...)
+}
+
+// Reset clears recorded events.
+func (s *EventServer) Reset() {
+	s.mx.Lock()
+	s.published = nil
+	s.mx.Unlock()
+}
@@ -0,0 +1,99 @@
+package wstest
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	sess "github.com/dronm/session"
+	"github.com/gin-gonic/gin"
+
+	"github.com/dronm/gobizapp/logger"
+	"github.com/dronm/gobizapp/services"
+	"github.com/dronm/gobizapp/ws"
+)
+
+const (
+	DefSessCookieKey = "wstest_sid"
+	shutdownTimeout  = time.Duration(5) * time.Second
+)
+
+// Server is a WSServer running on a local httptest listener
+// with in-memory sessions and the fake event server.
+type Server struct {
+	WS       *ws.WSServer
+	HTTP     *httptest.Server
+	Sessions *MemSessionManager
+	Events   *EventServer
+	URL      string // websocket URL
+
+	cookieKey string
+}
+
+// NewServer starts a test server. Empty WSInit fields are filled with test
+// defaults: in-memory session manager, fake event server and permission
+// callbacks allowing everything.
+// The global logger is initialized with error level if it is not set.
+func NewServer(wsInit ws.WSInit) *Server {
+	if logger.Logger == nil {
+		_ = logger.Initialize("error")
+	}
+	srv := &Server{}
+
+	if wsInit.SessManager == nil {
+		srv.Sessions = NewMemSessionManager()
+		wsInit.SessManager = srv.Sessions
+	} else if m, ok := wsInit.SessManager.(*MemSessionManager); ok {
+		srv.Sessions = m
+	}
+	if wsInit.EventServer == nil {
+		srv.Events = NewEventServer()
+		wsInit.EventServer = srv.Events
+	}
+	if wsInit.CheckPermission == nil {
+		wsInit.CheckPermission = func(method string) gin.HandlerFunc {
+			return func(c *gin.Context) { c.Next() }
+		}
+	}
+	if wsInit.SessCookieKey == "" {
+		wsInit.SessCookieKey = DefSessCookieKey
+	}
+	if wsInit.URL == "" {
+		wsInit.URL = "/"
+	}
+	srv.cookieKey = wsInit.SessCookieKey
+
+	srv.WS = ws.NewWSServer(wsInit)
+	if srv.Events != nil {
+		srv.Events.SocketServer = srv.WS
+	}
+	srv.HTTP = httptest.NewServer(srv.WS.Handler())
+	srv.URL = "ws" + strings.TrimPrefix(srv.HTTP.URL, "http") + wsInit.URL
+
+	return srv
+}
+
+// NewSession creates a session with the given values, e.g. user role.
+// It is available only with the default in-memory session manager.
+func (s *Server) NewSession(values map[string]any) sess.Session {
+	return s.Sessions.NewSession(values)
+}
+
+// SetEvHandler sets services.EvHandler to the test server,
+// so EventService and services publishing events work over it.
+// The returned function restores the previous handler.
+func (s *Server) SetEvHandler() func() {
+	prev := services.EvHandler
+	services.EvHandler = s.WS
+	return func() { services.EvHandler = prev }
+}
+
+// Close closes all connections and stops the listener.
+func (s *Server) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	s.WS.ShutdownWebSockets(ctx)
+	s.HTTP.Close()
+}
@@ -0,0 +1,56 @@
+package wstest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dronm/ds/pgds"
+	"github.com/dronm/session"
+
+	"github.com/dronm/gobizapp/api"
+	"github.com/dronm/gobizapp/ws"
+)
+
+const testEvent = "WSTest.Event"
+
+// echoService is a service without database access for the test calls.
+type echoService struct {
+	Session session.Session
+}
+
+func (s *echoService) SetDB(db *pgds.PgProvider)       {}
+func (s *echoService) SetSession(sess session.Session) { s.Session = sess }
+func (s *echoService) SetQueryID(queryID string)       {}
+
+func (s *echoService) Say(ctx context.Context, text string) (string, error) {
+	return "echo: " + text, nil
+}
+
+func TestCallAndEvent(t *testing.T) {
+	api.RegisterMethods("WSTestEcho", &echoService{})
+
+	srv := NewServer(ws.WSInit{})
+	defer srv.Close()
+
+	c := srv.MustDial(t, nil)
+
+	var res []string
+	c.MustCall(t, "WSTestEcho.Say", struct {
+		Text string `json:"text"`
+	}{Text: "hello"}, &res)
+	if len(res) != 1 || res[0] != "echo: hello" {
+		t.Fatalf("unexpected call result: %v", res)
+	}
+
+	if err := c.Subscribe(testEvent); err != nil {
+		t.Fatalf("Subscribe(): %v", err)
+	}
+	if err := srv.Events.PublishEvent("", testEvent, map[string]int{"id": 1}); err != nil {
+		t.Fatalf("PublishEvent(): %v", err)
+	}
+	var payload map[string]int
+	c.ExpectEvent(t, testEvent, &payload)
+	if payload["id"] != 1 {
+		t.Fatalf("unexpected event payload: %v", payload)
+	}
+}
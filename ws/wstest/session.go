@@ -0,0 +1,86 @@
+// Package wstest provides utilities for testing services over websockets
+// without Redis, Postgres and real network listeners.
+package wstest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	sess "github.com/dronm/session"
+
+	"github.com/dronm/gobizapp/session"
+)
+
+const defMaxLifeTime = 3600
+
+// MemSessionManager is an in-memory session manager.
+// Unknown or empty IDs start new sessions.
+type MemSessionManager struct {
+	mx          sync.Mutex
+	sessions    map[string]*session.MemSession
+	MaxLifeTime int64 // seconds, used for session cookie
+}
+
+func NewMemSessionManager() *MemSessionManager {
+	return &MemSessionManager{
+		sessions:    make(map[string]*session.MemSession),
+		MaxLifeTime: defMaxLifeTime,
+	}
+}
+
+func (m *MemSessionManager) SessionStart(id string) (sess.Session, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		return s, nil
+	}
+	if id == "" {
+		id = newID()
+	}
+	s := session.NewMemSession(id)
+	m.sessions[id] = s
+	return s, nil
+}
+
+func (m *MemSessionManager) GetMaxLifeTime() int64 {
+	return m.MaxLifeTime
+}
+
+// SessionDestroy removes the session.
+func (m *MemSessionManager) SessionDestroy(id string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// NewSession creates a session with the given values, e.g. user role.
+func (m *MemSessionManager) NewSession(values map[string]any) sess.Session {
+	s, _ := m.SessionStart("")
+	for k, v := range values {
+		_ = s.Set(k, v)
+	}
+	return s
+}
+
+// Session returns an existing session or nil.
+func (m *MemSessionManager) Session(id string) sess.Session {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		return s
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}